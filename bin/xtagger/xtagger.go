@@ -27,7 +27,7 @@ func main() {
 	//Run program
 	if err := program.Run(); err != nil {
 		slog.Log(context.Background(), logging.LevelFatal, err.Error())
-		global.ExitCode = global.ExitHardError
+		global.SetExitCode(global.ExitHardError)
 	}
-	os.Exit(int(global.ExitCode))
+	os.Exit(int(global.ExitCode()))
 }
@@ -3,8 +3,11 @@ module github.com/jwdev42/xtagger
 go 1.25.0
 
 require (
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/pkg/xattr v0.4.12
 	golang.org/x/crypto v0.50.0
+	golang.org/x/sys v0.43.0
+	lukechampine.com/blake3 v1.4.1
 )
 
-require golang.org/x/sys v0.43.0 // indirect
+require github.com/klauspost/cpuid/v2 v2.0.9 // indirect
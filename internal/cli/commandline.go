@@ -15,13 +15,18 @@
 package cli
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/jwdev42/xtagger/internal/hashes"
+	"github.com/jwdev42/xtagger/internal/record"
+	"github.com/jwdev42/xtagger/internal/xio/filesystem"
 	"log/slog"
+	"math"
 	"os"
 	"slices"
 	"strconv"
+	"time"
 )
 
 // Represents a parsed command line argument set.
@@ -29,15 +34,37 @@ type CommandLine struct {
 	command             Command //Specified command
 	paths               []string
 	names               []string
+	source              string
+	destination         string
+	checksum            string
+	indexAction         IndexAction
+	cacheAction         CacheAction
+	fileFormat          FileFormat
+	flagIndex           string
+	flagCache           string
+	flagRehash          bool
+	flagLock            time.Duration
 	flagLogLevel        slog.Level //parsed loglevel
 	flagFollowSymlinks  bool
 	flagHash            hashes.Algo
+	flagEncoding        record.Encoding
+	flagBackend         record.Backend
 	flagQuitOnSoftError bool
-	flagMultiThread     bool
+	flagWorkers         int
+	flagWalkers         int
+	flagOrdered         bool
+	flagSorted          bool
+	flagFilter          *filesystem.Filter
 	flagPrint0          bool
+	flagFast            bool
+	flagChunkSize       int64
+	flagDigestBlockSize int64
+	verifyBlocks        bool
 	printRecords        bool
+	verified            bool
 	forbidRecursion     bool
 	quota               int64
+	blockSize           int64
 	quotaContinue       bool
 	tagConstraint       TagConstraint
 	untagConstraint     UntagConstraint
@@ -56,6 +83,52 @@ func (r *CommandLine) Names() []string {
 	return r.names
 }
 
+func (r *CommandLine) Source() string {
+	return r.source
+}
+
+func (r *CommandLine) Destination() string {
+	return r.destination
+}
+
+// Returns the checksum to search for, empty if unset.
+func (r *CommandLine) Checksum() string {
+	return r.checksum
+}
+
+func (r *CommandLine) IndexAction() IndexAction {
+	return r.indexAction
+}
+
+func (r *CommandLine) CacheAction() CacheAction {
+	return r.cacheAction
+}
+
+// Returns the format of the file read by import or written by export.
+func (r *CommandLine) FileFormat() FileFormat {
+	return r.fileFormat
+}
+
+// Returns the path of the index, empty if unset.
+func (r *CommandLine) FlagIndex() string {
+	return r.flagIndex
+}
+
+// Returns the path of the hash cache, empty if unset.
+func (r *CommandLine) FlagCache() string {
+	return r.flagCache
+}
+
+// Returns true if files have to be hashed even if the hash cache holds their checksums.
+func (r *CommandLine) FlagRehash() bool {
+	return r.flagRehash
+}
+
+// Returns how long to wait for the lock of a file, 0 if locking is disabled.
+func (r *CommandLine) FlagLock() time.Duration {
+	return r.flagLock
+}
+
 func (r *CommandLine) FlagFollowSymlinks() bool {
 	return r.flagFollowSymlinks
 }
@@ -68,22 +141,88 @@ func (r *CommandLine) FlagHash() hashes.Algo {
 	return r.flagHash
 }
 
+func (r *CommandLine) FlagEncoding() record.Encoding {
+	return r.flagEncoding
+}
+
+// Returns the storage backend set on the command line, nil if unset.
+func (r *CommandLine) FlagBackend() record.Backend {
+	return r.flagBackend
+}
+
 func (r *CommandLine) FlagQuitOnSoftError() bool {
 	return r.flagQuitOnSoftError
 }
 
 func (r *CommandLine) FlagMultiThread() bool {
-	return r.flagMultiThread
+	return r.flagWorkers > 0
+}
+
+// Returns the number of workers for multithreading, 0 if multithreading is disabled.
+func (r *CommandLine) FlagWorkers() int {
+	return r.flagWorkers
+}
+
+// Returns the number of directories read concurrently, 0 if unset.
+func (r *CommandLine) FlagWalkers() int {
+	return r.flagWalkers
+}
+
+// Returns true if files have to be processed in a deterministic order.
+func (r *CommandLine) FlagOrdered() bool {
+	return r.flagOrdered
+}
+
+// Returns true if the entries of each directory have to be processed sorted by name.
+func (r *CommandLine) FlagSorted() bool {
+	return r.flagSorted
+}
+
+// Returns the filter for walked files, nil if no filter option was set.
+func (r *CommandLine) FlagFilter() *filesystem.Filter {
+	return r.flagFilter
+}
+
+// Returns the filter for walked files, creates it if necessary.
+func (r *CommandLine) filter() *filesystem.Filter {
+	if r.flagFilter == nil {
+		r.flagFilter = new(filesystem.Filter)
+	}
+	return r.flagFilter
 }
 
 func (r *CommandLine) FlagPrint0() bool {
 	return r.flagPrint0
 }
 
+func (r *CommandLine) FlagFast() bool {
+	return r.flagFast
+}
+
+// Returns the chunk size for tree hashing, 0 if files are hashed as a whole.
+func (r *CommandLine) FlagChunkSize() int64 {
+	return r.flagChunkSize
+}
+
+// Returns the block size for per-block digests, 0 if none are stored.
+func (r *CommandLine) FlagDigestBlockSize() int64 {
+	return r.flagDigestBlockSize
+}
+
+// Returns true if verify has to locate corrupted blocks of mismatching files.
+func (r *CommandLine) VerifyBlocks() bool {
+	return r.verifyBlocks
+}
+
 func (r *CommandLine) FlagPrintRecords() bool {
 	return r.printRecords
 }
 
+// Returns true if imported records have to match the file's content.
+func (r *CommandLine) Verified() bool {
+	return r.verified
+}
+
 func (r *CommandLine) ForbidRecursion() bool {
 	return r.forbidRecursion
 }
@@ -92,6 +231,11 @@ func (r *CommandLine) SizeQuota() int64 {
 	return r.quota
 }
 
+// Returns the block size for block-oriented output, 0 if unset.
+func (r *CommandLine) BlockSize() int64 {
+	return r.blockSize
+}
+
 func (r *CommandLine) FlagQuotaContinue() bool {
 	return r.quotaContinue
 }
@@ -117,7 +261,83 @@ func (r *CommandLine) parseHashAlgo(input string) error {
 	return nil
 }
 
+func (r *CommandLine) parseEncoding(input string) error {
+	encoding, err := record.ParseEncoding(input)
+	if err != nil {
+		return err
+	}
+	r.flagEncoding = encoding
+	return nil
+}
+
+func (r *CommandLine) parseBackend(input string) error {
+	backend, err := record.NewBackend(input)
+	if err != nil {
+		return err
+	}
+	r.flagBackend = backend
+	return nil
+}
+
 func (r *CommandLine) parseSizeStatement(input string) error {
+	size, err := parseSize(input)
+	if err != nil {
+		return err
+	}
+	r.quota = size
+	return nil
+}
+
+func (r *CommandLine) parseBlockSizeStatement(input string) error {
+	size, err := parseSize(input)
+	if err != nil {
+		return err
+	}
+	if size < 1 || size > math.MaxInt32 {
+		return fmt.Errorf("Block size out of range: %d", size)
+	}
+	r.blockSize = size
+	return nil
+}
+
+func (r *CommandLine) parseChunkSize(input string) error {
+	size, err := parseSize(input)
+	if err != nil {
+		return err
+	}
+	if size < 1 {
+		return fmt.Errorf("Chunk size must be positive: %d", size)
+	}
+	r.flagChunkSize = size
+	return nil
+}
+
+func (r *CommandLine) parseWalkers(input string) error {
+	walkers, err := strconv.Atoi(input)
+	if err != nil {
+		return fmt.Errorf("Could not parse number of walkers: %s", err)
+	}
+	if walkers < 1 {
+		return fmt.Errorf("Number of walkers must be positive: %d", walkers)
+	}
+	r.flagWalkers = walkers
+	return nil
+}
+
+func (r *CommandLine) parseDigestBlockSize(input string) error {
+	size, err := parseSize(input)
+	if err != nil {
+		return err
+	}
+	if size < 1 {
+		return fmt.Errorf("Block size must be positive: %d", size)
+	}
+	r.flagDigestBlockSize = size
+	return nil
+}
+
+// Parses a SIZE_SPEC and returns the size in bytes.
+func parseSize(input string) (int64, error) {
 	var base = make([]rune, len(input))
 	var suffix string
 	//Parse size limit integer
@@ -129,30 +349,34 @@ func (r *CommandLine) parseSizeStatement(input string) error {
 		}
 		base[i] = ch
 	}
-	sizeLimit, err := strconv.ParseInt(string(base), 10, 64)
+	size, err := strconv.ParseInt(string(base), 10, 64)
 	if err != nil {
-		return fmt.Errorf("Could not parse size statement: %s", err)
+		return 0, fmt.Errorf("Could not parse size statement: %s", err)
 	}
 	//Parse optional size suffix
 	const kib = 1024
 	const mib = kib * 1024
 	const gib = mib * 1024
 	const tib = gib * 1024
+	var multiplier int64
 	switch suffix {
 	case "":
-		r.quota = sizeLimit
+		multiplier = 1
 	case "K":
-		r.quota = sizeLimit * kib
+		multiplier = kib
 	case "M":
-		r.quota = sizeLimit * mib
+		multiplier = mib
 	case "G":
-		r.quota = sizeLimit * gib
+		multiplier = gib
 	case "T":
-		r.quota = sizeLimit * tib
+		multiplier = tib
 	default:
-		return fmt.Errorf("Could not parse size statement: Unknown suffix: \"%s\"", suffix)
+		return 0, fmt.Errorf("Could not parse size statement: Unknown suffix: \"%s\"", suffix)
 	}
-	return nil
+	if size > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("Could not parse size statement: %s exceeds the maximum size", input)
+	}
+	return size * multiplier, nil
 }
 
 // Parses and validates command line arguments.
@@ -166,13 +390,52 @@ func ParseCommandLine() (*CommandLine, error) {
 	main.BoolVar(&cmd.flagFollowSymlinks, "symlinks", false, "Program follows symlinks if true")
 	main.Func("hash", "Specify the hashing algorithm", cmd.parseHashAlgo)
 	main.Func("limit", "Specify the size limit", cmd.parseSizeStatement)
+	main.Func("encoding", "Specify the encoding of stored attributes (json or binary)", cmd.parseEncoding)
+	main.StringVar(&cmd.flagIndex, "index", "", "Keep the index at the given path up to date")
+	main.StringVar(&cmd.flagCache, "cache", "", "Take checksums of unchanged files from the hash cache at the given path")
+	main.BoolVar(&cmd.flagRehash, "rehash", false, "Hash all files and refresh the hash cache instead of reading it")
+	main.DurationVar(&cmd.flagLock, "lock", 0, "Lock files while their attribute is modified, wait for at most the given duration")
+	main.Func("backend", "Specify where attributes are stored (xattr, sidecar or manifest:FILE)", cmd.parseBackend)
 	main.BoolVar(&cmd.flagQuitOnSoftError, "hard", false, "Quit on every error if true")
-	main.BoolVar(&cmd.flagMultiThread, "mt", false, "Enable multithreading on supported subroutines")
+	var workers = &flagWorkers{}
+	main.Var(workers, "mt", "Enable multithreading on supported subroutines, -mt=N sets the number of workers")
+	main.Func("walkers", "Read the given number of directories concurrently", cmd.parseWalkers)
+	main.BoolVar(&cmd.flagOrdered, "ordered", false, "Process files in the order of a sequential walk")
+	main.BoolVar(&cmd.flagSorted, "sorted", false, "Process the entries of each directory sorted by name")
+	main.Func("include", "Only process files matching the glob pattern, can be repeated", func(input string) error {
+		return cmd.filter().Include(input)
+	})
+	main.Func("exclude", "Skip files and directories matching the glob pattern, can be repeated", func(input string) error {
+		return cmd.filter().Exclude(input)
+	})
+	main.Func("include-regex", "Only process files matching the regular expression, can be repeated", func(input string) error {
+		return cmd.filter().IncludeRegexp(input)
+	})
+	main.Func("exclude-regex", "Skip files and directories matching the regular expression, can be repeated", func(input string) error {
+		return cmd.filter().ExcludeRegexp(input)
+	})
+	main.Func("ignore-file", "Read ignore patterns from files with the given name in every directory", func(input string) error {
+		return cmd.filter().SetIgnoreFile(input)
+	})
 	main.BoolVar(&cmd.flagPrint0, "print0", false, "Print processed file paths null-terminated")
+	main.BoolVar(&cmd.flagFast, "fast", false, "Invalidate records by comparing file size and modification time instead of hashing")
+	main.Func("chunk", "Hash files as a tree of chunks of the given size, chunks are hashed in parallel", cmd.parseChunkSize)
+	main.Func("blocks", "Store a digest for each block of the given size next to the record", cmd.parseDigestBlockSize)
 	if err := main.Parse(os.Args[1:]); err != nil {
 		return nil, err
 	}
+	if cmd.flagChunkSize > 0 && cmd.flagDigestBlockSize > 0 {
+		return nil, errors.New("Options -chunk and -blocks are mutually exclusive")
+	}
+	if cmd.flagLock < 0 {
+		return nil, fmt.Errorf("Option -lock cannot be negative, got %s", cmd.flagLock)
+	}
+	//The manifest is written once the run ends, a lock per file can't protect it
+	if _, ok := cmd.flagBackend.(*record.ManifestBackend); ok && cmd.flagLock > 0 {
+		return nil, errors.New("Option -lock is not supported by the manifest backend")
+	}
 	cmd.flagLogLevel = logLevel.Get().(slog.Level)
+	cmd.flagWorkers = workers.Get().(int)
 	//Stage 2: Parse command
 	p := &parser{
 		tokens:      main.Args(),
@@ -199,24 +462,87 @@ func (a *CommandLine) mustEqual(b *CommandLine) error {
 	if slices.Compare(a.names, b.names) != 0 {
 		return differs("names", a.names, b.names)
 	}
+	if a.source != b.source {
+		return differs("source", a.source, b.source)
+	}
+	if a.verified != b.verified {
+		return differs("verified", a.verified, b.verified)
+	}
+	if a.destination != b.destination {
+		return differs("destination", a.destination, b.destination)
+	}
+	if a.checksum != b.checksum {
+		return differs("checksum", a.checksum, b.checksum)
+	}
+	if a.indexAction != b.indexAction {
+		return differs("indexAction", a.indexAction, b.indexAction)
+	}
+	if a.cacheAction != b.cacheAction {
+		return differs("cacheAction", a.cacheAction, b.cacheAction)
+	}
+	if a.fileFormat != b.fileFormat {
+		return differs("fileFormat", a.fileFormat, b.fileFormat)
+	}
+	if a.printRecords != b.printRecords {
+		return differs("printRecords", a.printRecords, b.printRecords)
+	}
 	if a.flagLogLevel != b.flagLogLevel {
 		return differs("flagLogLevel", a.flagLogLevel, b.flagLogLevel)
 	}
+	if a.flagCache != b.flagCache {
+		return differs("flagCache", a.flagCache, b.flagCache)
+	}
+	if a.flagRehash != b.flagRehash {
+		return differs("flagRehash", a.flagRehash, b.flagRehash)
+	}
+	if a.flagLock != b.flagLock {
+		return differs("flagLock", a.flagLock, b.flagLock)
+	}
 	if a.flagFollowSymlinks != b.flagFollowSymlinks {
 		return differs("flagFollowSymlinks", a.flagFollowSymlinks, b.flagFollowSymlinks)
 	}
 	if a.flagHash != b.flagHash {
 		return differs("flagHash", a.flagHash, b.flagHash)
 	}
+	if a.flagEncoding != b.flagEncoding {
+		return differs("flagEncoding", a.flagEncoding, b.flagEncoding)
+	}
 	if a.flagQuitOnSoftError != b.flagQuitOnSoftError {
 		return differs("flagQuitOnSoftError", a.flagQuitOnSoftError, b.flagQuitOnSoftError)
 	}
-	if a.flagMultiThread != b.flagMultiThread {
-		return differs("flagMultiThread", a.flagMultiThread, b.flagMultiThread)
+	if a.flagWorkers != b.flagWorkers {
+		return differs("flagWorkers", a.flagWorkers, b.flagWorkers)
+	}
+	if a.flagWalkers != b.flagWalkers {
+		return differs("flagWalkers", a.flagWalkers, b.flagWalkers)
+	}
+	if a.flagOrdered != b.flagOrdered {
+		return differs("flagOrdered", a.flagOrdered, b.flagOrdered)
+	}
+	if a.flagSorted != b.flagSorted {
+		return differs("flagSorted", a.flagSorted, b.flagSorted)
 	}
 	if a.flagPrint0 != b.flagPrint0 {
 		return differs("flagPrint0", a.flagPrint0, b.flagPrint0)
 	}
+	if a.quota != b.quota {
+		return differs("quota", a.quota, b.quota)
+	}
+	if a.blockSize != b.blockSize {
+		return differs("blockSize", a.blockSize, b.blockSize)
+	}
+	if a.flagChunkSize != b.flagChunkSize {
+		return differs("flagChunkSize", a.flagChunkSize, b.flagChunkSize)
+	}
+	if a.flagDigestBlockSize != b.flagDigestBlockSize {
+		return differs("flagDigestBlockSize", a.flagDigestBlockSize, b.flagDigestBlockSize)
+	}
+	if a.verifyBlocks != b.verifyBlocks {
+		return differs("verifyBlocks", a.verifyBlocks, b.verifyBlocks)
+	}
+	if a.flagFast != b.flagFast {
+		return differs("flagFast", a.flagFast, b.flagFast)
+	}
 	if a.tagConstraint != b.tagConstraint {
 		return differs("tagConstraint", a.tagConstraint, b.tagConstraint)
 	}
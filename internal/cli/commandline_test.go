@@ -15,12 +15,14 @@
 package cli
 
 import (
+	"os"
+	"runtime"
 	"testing"
 )
 
 func TestParseSizeStatement(t *testing.T) {
 	//test definitions
-	tests := make(map[string]uint64)
+	tests := make(map[string]int64)
 	tests["0"] = 0
 	tests["10"] = 10
 	tests["010"] = 10
@@ -36,8 +38,8 @@ func TestParseSizeStatement(t *testing.T) {
 		if err := cmd.parseSizeStatement(input); err != nil {
 			t.Errorf("Error for input \"%s\": %s", input, err)
 		}
-		if expectedOutput != cmd.sizeLimit {
-			t.Errorf("Error for input \"%s\": Expected size limit is %d, but received size limit is %d", input, expectedOutput, cmd.sizeLimit)
+		if expectedOutput != cmd.quota {
+			t.Errorf("Error for input \"%s\": Expected size limit is %d, but received size limit is %d", input, expectedOutput, cmd.quota)
 		}
 	}
 }
@@ -66,6 +68,8 @@ func TestParseSizeStatementNegative(t *testing.T) {
 		"62,78K",
 		"77.2M",
 		"18446744073709551616", //uint64 overflow
+		"9223372036854775808",  //int64 overflow
+		"8388608T",             //int64 overflow after applying the suffix
 	}
 	//run negative tests
 	cmd := new(CommandLine)
@@ -73,8 +77,60 @@ func TestParseSizeStatementNegative(t *testing.T) {
 		if err := cmd.parseSizeStatement(input); err == nil {
 			t.Errorf("Expected an error for input \"%s\"", input)
 		}
-		if cmd.sizeLimit != 0 {
+		if cmd.quota != 0 {
 			t.Errorf("Test input \"%s\" did modify the size limit despite having an error", input)
 		}
 	}
 }
+
+func TestFlagWorkers(t *testing.T) {
+	tests := map[string]int{
+		"true":  runtime.GOMAXPROCS(0),
+		"false": 0,
+		"1":     1,
+		"64":    64,
+	}
+	for input, expected := range tests {
+		workers := new(flagWorkers)
+		if err := workers.Set(input); err != nil {
+			t.Errorf("Error for input \"%s\": %s", input, err)
+		}
+		if workers.Get().(int) != expected {
+			t.Errorf("Error for input \"%s\": Expected %d workers, got %d", input, expected, workers.Get())
+		}
+	}
+	for _, input := range []string{"", "0", "-1", "abc", "2.5"} {
+		if err := new(flagWorkers).Set(input); err == nil {
+			t.Errorf("Expected an error for input \"%s\"", input)
+		}
+	}
+}
+
+func TestParseWalkers(t *testing.T) {
+	cmd := new(CommandLine)
+	if err := cmd.parseWalkers("8"); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if cmd.flagWalkers != 8 {
+		t.Errorf("Expected 8 walkers, got %d", cmd.flagWalkers)
+	}
+	for _, input := range []string{"", "0", "-1", "abc"} {
+		if err := cmd.parseWalkers(input); err == nil {
+			t.Errorf("Expected an error for input \"%s\"", input)
+		}
+	}
+}
+
+func TestLockWithManifest(t *testing.T) {
+	defer func(args []string) {
+		os.Args = args
+	}(os.Args)
+	os.Args = []string{"xtagger", "-backend", "sidecar", "-lock", "1s", "tag", "as", "foo", "for", "tmp"}
+	if _, err := ParseCommandLine(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	os.Args = []string{"xtagger", "-backend", "manifest:tmp.json", "-lock", "1s", "tag", "as", "foo", "for", "tmp"}
+	if _, err := ParseCommandLine(); err == nil {
+		t.Errorf("Expected an error for option -lock with the manifest backend")
+	}
+}
@@ -21,7 +21,37 @@ const (
 	CommandUntag              = "untag"
 	CommandInvalidate         = "invalidate"
 	CommandRevalidate         = "revalidate"
+	CommandVerify             = "verify"
+	CommandCopy               = "copy"
+	CommandArchive            = "archive"
+	CommandMigrate            = "migrate"
+	CommandIndex              = "index"
+	CommandExport             = "export"
+	CommandImport             = "import"
+	CommandHashes             = "hashes"
+	CommandCache              = "cache"
 	CommandLicenses           = "licenses"
 )
 
+const (
+	IndexActionNone    IndexAction = ""
+	IndexActionRebuild             = "rebuild"
+	IndexActionQuery               = "query"
+)
+
+const (
+	CacheActionNone  CacheAction = ""
+	CacheActionPrune             = "prune"
+	CacheActionStats             = "stats"
+)
+
+const (
+	FileFormatRecords      FileFormat = ""
+	FileFormatChecksums               = "checksums"
+	FileFormatBSDChecksums            = "bsd"
+)
+
 type Command string
+type IndexAction string
+type CacheAction string
+type FileFormat string
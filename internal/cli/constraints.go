@@ -25,6 +25,8 @@ const (
 	PrintConstraintValid
 	PrintConstraintInvalid
 	PrintConstraintUntagged
+	PrintConstraintModified
+	PrintConstraintCorrupted
 )
 
 const (
@@ -0,0 +1,59 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+)
+
+// Type for parsing the worker count of -mt. The flag can be used like a
+// boolean flag, -mt alone selects one worker per CPU.
+type flagWorkers struct {
+	workers int
+}
+
+func (r *flagWorkers) Get() any {
+	return r.workers
+}
+
+func (r *flagWorkers) String() string {
+	if r == nil {
+		return ""
+	}
+	return strconv.Itoa(r.workers)
+}
+
+func (r *flagWorkers) Set(value string) error {
+	switch value {
+	case "true":
+		r.workers = runtime.GOMAXPROCS(0)
+		return nil
+	case "false":
+		r.workers = 0
+		return nil
+	}
+	workers, err := strconv.Atoi(value)
+	if err != nil || workers < 1 {
+		return fmt.Errorf("Worker count must be a positive number: %q", value)
+	}
+	r.workers = workers
+	return nil
+}
+
+func (r *flagWorkers) IsBoolFlag() bool {
+	return true
+}
@@ -78,7 +78,34 @@ func (r *parser) parseCommand() (Command, error) {
 		err = r.parseCommandUntag()
 	case CommandInvalidate, CommandRevalidate:
 		r.adv()
-		err = r.parseCommandInvalidateOrRevalidate()
+		err = r.parseCommandRecordSelection()
+	case CommandVerify:
+		r.adv()
+		err = r.parseCommandVerify()
+	case CommandCopy:
+		r.adv()
+		err = r.parseCommandCopy()
+	case CommandArchive:
+		r.adv()
+		err = r.parseCommandArchive()
+	case CommandMigrate:
+		r.adv()
+		err = r.parseCommandMigrate()
+	case CommandIndex:
+		r.adv()
+		err = r.parseCommandIndex()
+	case CommandExport:
+		r.adv()
+		err = r.parseCommandExport()
+	case CommandImport:
+		r.adv()
+		err = r.parseCommandImport()
+	case CommandHashes:
+		r.adv()
+		err = r.parseCommandHashes()
+	case CommandCache:
+		r.adv()
+		err = r.parseCommandCache()
 	case CommandLicenses:
 		r.adv()
 		err = r.parseCommandLicense()
@@ -124,6 +151,83 @@ func (r *parser) parseCommandTag() error {
 	return r.parsePathsUntilEOF()
 }
 
+func (r *parser) parseCommandCopy() error {
+	//parse "as"
+	if err := r.parseLiteral("as"); err != nil {
+		//if "as" is not found, parse tag constraint, then "as"
+		if err := r.parseTagConstraint(); err != nil {
+			return err
+		}
+		if err := r.parseLiteral("as"); err != nil {
+			return err
+		}
+	}
+	//Parse tag name
+	if err := r.parseName(); err != nil {
+		return err
+	}
+	//Parse "to"
+	if err := r.parseLiteral("to"); err != nil {
+		return err
+	}
+	//Parse destination
+	if err := r.parseDestination(); err != nil {
+		return err
+	}
+	//Parse "for"
+	if err := r.parseLiteral("for"); err != nil {
+		return err
+	}
+	//Parse path(s)
+	return r.parsePathsUntilEOF()
+}
+
+func (r *parser) parseCommandArchive() error {
+	//Parse "as"
+	if err := r.parseLiteral("as"); err != nil {
+		return err
+	}
+	//Parse tag name
+	if err := r.parseName(); err != nil {
+		return err
+	}
+	//Parse optional size restriction
+	tok, ok := r.tok()
+	if !ok {
+		return io.EOF
+	}
+	if tok == "up" {
+		if err := r.parseTagSizeLimit(); err != nil {
+			return err
+		}
+	}
+	//Parse "to"
+	if err := r.parseLiteral("to"); err != nil {
+		return err
+	}
+	//Parse archive file
+	if err := r.parseDestination(); err != nil {
+		return err
+	}
+	//Parse optional block size
+	if err := r.parseLiteral("blocksize"); err == nil {
+		tok, ok := r.tok()
+		if !ok {
+			return io.EOF
+		}
+		if err := r.commandLine.parseBlockSizeStatement(tok); err != nil {
+			return err
+		}
+		r.adv()
+	}
+	//Parse "for"
+	if err := r.parseLiteral("for"); err != nil {
+		return err
+	}
+	//Parse path(s)
+	return r.parsePathsUntilEOF()
+}
+
 func (r *parser) parseCommandPrint() error {
 	if err := r.parseLiteral("untagged"); err == nil {
 		//Parse "for" after "untagged"
@@ -166,7 +270,8 @@ func (r *parser) parseCommandUntag() error {
 	return r.parsePathsUntilEOF()
 }
 
-func (r *parser) parseCommandInvalidateOrRevalidate() error {
+// Parses "{ all | NAMES } for PATHS", shared by invalidate, revalidate and verify.
+func (r *parser) parseCommandRecordSelection() error {
 	//parse "all"
 	if err := r.parseLiteral("all"); err != nil {
 		//parse NAMES if token is not "all"
@@ -182,6 +287,218 @@ func (r *parser) parseCommandInvalidateOrRevalidate() error {
 	return r.parsePathsUntilEOF()
 }
 
+func (r *parser) parseCommandVerify() error {
+	//Parse optional "blocks"
+	if err := r.parseLiteral("blocks"); err == nil {
+		r.commandLine.verifyBlocks = true
+	}
+	return r.parseCommandRecordSelection()
+}
+
+func (r *parser) parseCommandMigrate() error {
+	//parse "for"
+	if err := r.parseLiteral("for"); err != nil {
+		return err
+	}
+	//parse PATHS
+	return r.parsePathsUntilEOF()
+}
+
+func (r *parser) parseCommandIndex() error {
+	tok, ok := r.tok()
+	if !ok {
+		return io.EOF
+	}
+	switch IndexAction(tok) {
+	case IndexActionRebuild:
+		r.adv()
+		r.commandLine.indexAction = IndexActionRebuild
+		//Parse "for"
+		if err := r.parseLiteral("for"); err != nil {
+			return err
+		}
+		//Parse PATHS
+		return r.parsePathsUntilEOF()
+	case IndexActionQuery:
+		r.adv()
+		r.commandLine.indexAction = IndexActionQuery
+		return r.parseIndexQuery()
+	}
+	return r.error(IndexActionRebuild, IndexActionQuery)
+}
+
+func (r *parser) parseIndexQuery() error {
+	//Parse optional CONSTRAINT, return value can therefore be ignored
+	r.parsePrintConstraint()
+	//Parse optional literal "records"
+	if err := r.parseLiteral("records"); err == nil {
+		r.commandLine.printRecords = true
+	}
+	//Parse optional "by" + NAMES
+	if err := r.parseLiteral("by"); err == nil {
+		if err := r.parseNames(); err != nil {
+			return err
+		}
+	}
+	//Parse optional "checksum" + CHECKSUM
+	if err := r.parseLiteral("checksum"); err == nil {
+		if err := r.parseChecksum(); err != nil {
+			return err
+		}
+	}
+	//Catch "EOF" token
+	if _, ok := r.tok(); ok {
+		return r.error(io.EOF.Error())
+	}
+	return nil
+}
+
+func (r *parser) parseChecksum() error {
+	tok, ok := r.tok()
+	if !ok {
+		return io.EOF
+	}
+	for i, ch := range tok {
+		if !(ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f' || ch >= 'A' && ch <= 'F') {
+			return fmt.Errorf("Checksum has illegal character at index %d", i)
+		}
+	}
+	r.commandLine.checksum = strings.ToLower(tok)
+	r.adv()
+	return nil
+}
+
+func (r *parser) parseCommandExport() error {
+	//Parse optional "checksums"
+	if err := r.parseLiteral("checksums"); err == nil {
+		return r.parseExportChecksums()
+	}
+	//Parse optional "by" + NAMES
+	if err := r.parseLiteral("by"); err == nil {
+		if err := r.parseNames(); err != nil {
+			return err
+		}
+	}
+	//Parse "to"
+	if err := r.parseLiteral("to"); err != nil {
+		return err
+	}
+	//Parse export file
+	if err := r.parseDestination(); err != nil {
+		return err
+	}
+	//Parse "for"
+	if err := r.parseLiteral("for"); err != nil {
+		return err
+	}
+	//Parse PATHS
+	return r.parsePathsUntilEOF()
+}
+
+func (r *parser) parseExportChecksums() error {
+	r.commandLine.fileFormat = FileFormatChecksums
+	//Parse optional "bsd"
+	if err := r.parseLiteral("bsd"); err == nil {
+		r.commandLine.fileFormat = FileFormatBSDChecksums
+	}
+	//Parse "by name" + NAME
+	if err := r.parseLiteral("by"); err != nil {
+		return err
+	}
+	if err := r.parseLiteral("name"); err != nil {
+		return err
+	}
+	if err := r.parseName(); err != nil {
+		return err
+	}
+	//Parse "to"
+	if err := r.parseLiteral("to"); err != nil {
+		return err
+	}
+	//Parse checksum file
+	if err := r.parseDestination(); err != nil {
+		return err
+	}
+	//Parse "for"
+	if err := r.parseLiteral("for"); err != nil {
+		return err
+	}
+	//Parse PATHS
+	return r.parsePathsUntilEOF()
+}
+
+func (r *parser) parseCommandImport() error {
+	//Parse optional "checksums"
+	if err := r.parseLiteral("checksums"); err == nil {
+		r.commandLine.fileFormat = FileFormatChecksums
+	}
+	//Parse optional "verified"
+	if err := r.parseLiteral("verified"); err == nil {
+		r.commandLine.verified = true
+	}
+	//Parse "from"
+	if err := r.parseLiteral("from"); err != nil {
+		return err
+	}
+	//Parse import file
+	if err := r.parseSource(); err != nil {
+		return err
+	}
+	//Checksum files need a name for the records, parse "as" + NAME
+	if r.commandLine.fileFormat == FileFormatChecksums {
+		if err := r.parseLiteral("as"); err != nil {
+			return err
+		}
+		if err := r.parseName(); err != nil {
+			return err
+		}
+	}
+	//Parse "into"
+	if err := r.parseLiteral("into"); err != nil {
+		return err
+	}
+	//Parse ROOT
+	if err := r.parseDestination(); err != nil {
+		return err
+	}
+	//Catch "EOF" token
+	if _, ok := r.tok(); ok {
+		return r.error(io.EOF.Error())
+	}
+	return nil
+}
+
+func (r *parser) parseCommandHashes() error {
+	//Parse "list"
+	if err := r.parseLiteral("list"); err != nil {
+		return err
+	}
+	//Catch "EOF" token
+	if _, ok := r.tok(); ok {
+		return r.error(io.EOF.Error())
+	}
+	return nil
+}
+
+func (r *parser) parseCommandCache() error {
+	tok, ok := r.tok()
+	if !ok {
+		return io.EOF
+	}
+	switch CacheAction(tok) {
+	case CacheActionPrune, CacheActionStats:
+		r.adv()
+		r.commandLine.cacheAction = CacheAction(tok)
+	default:
+		return r.error(CacheActionPrune, CacheActionStats)
+	}
+	//Catch "EOF" token
+	if _, ok := r.tok(); ok {
+		return r.error(io.EOF.Error())
+	}
+	return nil
+}
+
 func (r *parser) parseCommandLicense() error {
 	//catch "EOF" token
 	_, ok := r.tok()
@@ -236,15 +553,19 @@ func (r *parser) parseTagSizeLimit() error {
 func (r *parser) parsePrintConstraint() error {
 	tok, ok := r.tok()
 	if !ok {
-		return r.error("invalid", "valid")
+		return r.error("invalid", "valid", "modified", "corrupted")
 	}
 	switch tok {
 	case "invalid":
 		r.commandLine.printConstraint = PrintConstraintInvalid
 	case "valid":
 		r.commandLine.printConstraint = PrintConstraintValid
+	case "modified":
+		r.commandLine.printConstraint = PrintConstraintModified
+	case "corrupted":
+		r.commandLine.printConstraint = PrintConstraintCorrupted
 	default:
-		return r.error("invalid", "valid")
+		return r.error("invalid", "valid", "modified", "corrupted")
 	}
 	r.adv()
 	return nil
@@ -297,6 +618,32 @@ func (r *parser) parsePath() error {
 	return nil
 }
 
+func (r *parser) parseSource() error {
+	tok, ok := r.tok()
+	if !ok {
+		return io.EOF
+	}
+	if len(tok) < 1 {
+		return errors.New("Source cannot be empty")
+	}
+	r.commandLine.source = tok
+	r.adv()
+	return nil
+}
+
+func (r *parser) parseDestination() error {
+	tok, ok := r.tok()
+	if !ok {
+		return io.EOF
+	}
+	if len(tok) < 1 {
+		return errors.New("Destination cannot be empty")
+	}
+	r.commandLine.destination = tok
+	r.adv()
+	return nil
+}
+
 func (r *parser) parseNames() error {
 	if err := r.parseLiteral("name"); err != nil {
 		return err
@@ -51,6 +51,34 @@ func TestParser(t *testing.T) {
 			paths:         []string{"/tmp"},
 			tagConstraint: TagConstraintInvalid,
 		},
+		{"copy", "as", "foo", "to", "/backup", "for", "/tmp"}: {
+			command:       CommandCopy,
+			names:         []string{"foo"},
+			destination:   "/backup",
+			paths:         []string{"/tmp"},
+			tagConstraint: TagConstraintNone,
+		},
+		{"copy", "untagged", "as", "foo", "to", "for", "for", "tmp", "tmp2"}: {
+			command:       CommandCopy,
+			names:         []string{"foo"},
+			destination:   "for",
+			paths:         []string{"tmp", "tmp2"},
+			tagConstraint: TagConstraintUntagged,
+		},
+		{"archive", "as", "tape1", "to", "/dev/nst0", "for", "/tmp"}: {
+			command:     CommandArchive,
+			names:       []string{"tape1"},
+			destination: "/dev/nst0",
+			paths:       []string{"/tmp"},
+		},
+		{"archive", "as", "tape1", "up", "to", "2T", "to", "/dev/nst0", "blocksize", "256K", "for", "tmp", "tmp2"}: {
+			command:     CommandArchive,
+			names:       []string{"tape1"},
+			destination: "/dev/nst0",
+			quota:       2 * 1024 * 1024 * 1024 * 1024,
+			blockSize:   256 * 1024,
+			paths:       []string{"tmp", "tmp2"},
+		},
 		{"untag", "all", "for", "/tmp"}: {
 			command:         CommandUntag,
 			names:           nil,
@@ -111,6 +139,19 @@ func TestParser(t *testing.T) {
 			paths:           []string{"test"},
 			printConstraint: PrintConstraintInvalid,
 		},
+		{"print", "modified", "for", "test"}: {
+			command:         CommandPrint,
+			names:           nil,
+			paths:           []string{"test"},
+			printConstraint: PrintConstraintModified,
+		},
+		{"print", "corrupted", "records", "by", "name", "foo", "for", "test"}: {
+			command:         CommandPrint,
+			names:           []string{"foo"},
+			paths:           []string{"test"},
+			printConstraint: PrintConstraintCorrupted,
+			printRecords:    true,
+		},
 		{"print", "untagged", "for", "test"}: {
 			command:         CommandPrint,
 			names:           nil,
@@ -147,6 +188,104 @@ func TestParser(t *testing.T) {
 			names:   []string{"foo", "bar"},
 			paths:   []string{"test"},
 		},
+		{"migrate", "for", "test", "test2"}: {
+			command: CommandMigrate,
+			names:   nil,
+			paths:   []string{"test", "test2"},
+		},
+		{"index", "rebuild", "for", "test", "test2"}: {
+			command:     CommandIndex,
+			indexAction: IndexActionRebuild,
+			paths:       []string{"test", "test2"},
+		},
+		{"index", "query"}: {
+			command:     CommandIndex,
+			indexAction: IndexActionQuery,
+		},
+		{"index", "query", "invalid", "records", "by", "name", "foo", "checksum", "ABCdef0123"}: {
+			command:         CommandIndex,
+			indexAction:     IndexActionQuery,
+			printConstraint: PrintConstraintInvalid,
+			printRecords:    true,
+			names:           []string{"foo"},
+			checksum:        "abcdef0123",
+		},
+		{"index", "query", "corrupted"}: {
+			command:         CommandIndex,
+			indexAction:     IndexActionQuery,
+			printConstraint: PrintConstraintCorrupted,
+		},
+		{"export", "to", "records.jsonl", "for", "test", "test2"}: {
+			command:     CommandExport,
+			destination: "records.jsonl",
+			paths:       []string{"test", "test2"},
+		},
+		{"export", "by", "name", "foo", "and", "name", "bar", "to", "records.jsonl", "for", "test"}: {
+			command:     CommandExport,
+			names:       []string{"foo", "bar"},
+			destination: "records.jsonl",
+			paths:       []string{"test"},
+		},
+		{"import", "from", "records.jsonl", "into", "test"}: {
+			command:     CommandImport,
+			source:      "records.jsonl",
+			destination: "test",
+		},
+		{"import", "verified", "from", "records.jsonl", "into", "test"}: {
+			command:     CommandImport,
+			verified:    true,
+			source:      "records.jsonl",
+			destination: "test",
+		},
+		{"export", "checksums", "by", "name", "foo", "to", "SHA256SUMS", "for", "test"}: {
+			command:     CommandExport,
+			fileFormat:  FileFormatChecksums,
+			names:       []string{"foo"},
+			destination: "SHA256SUMS",
+			paths:       []string{"test"},
+		},
+		{"export", "checksums", "bsd", "by", "name", "foo", "to", "CHECKSUMS", "for", "test", "test2"}: {
+			command:     CommandExport,
+			fileFormat:  FileFormatBSDChecksums,
+			names:       []string{"foo"},
+			destination: "CHECKSUMS",
+			paths:       []string{"test", "test2"},
+		},
+		{"import", "checksums", "verified", "from", "SHA256SUMS", "as", "foo", "into", "test"}: {
+			command:     CommandImport,
+			fileFormat:  FileFormatChecksums,
+			verified:    true,
+			names:       []string{"foo"},
+			source:      "SHA256SUMS",
+			destination: "test",
+		},
+		{"hashes", "list"}: {
+			command: CommandHashes,
+		},
+		{"cache", "prune"}: {
+			command:     CommandCache,
+			cacheAction: CacheActionPrune,
+		},
+		{"cache", "stats"}: {
+			command:     CommandCache,
+			cacheAction: CacheActionStats,
+		},
+		{"verify", "blocks", "name", "foo", "for", "test"}: {
+			command:      CommandVerify,
+			verifyBlocks: true,
+			names:        []string{"foo"},
+			paths:        []string{"test"},
+		},
+		{"verify", "all", "for", "test"}: {
+			command: CommandVerify,
+			names:   nil,
+			paths:   []string{"test"},
+		},
+		{"verify", "name", "foo", "and", "name", "bar", "for", "test", "test2"}: {
+			command: CommandVerify,
+			names:   []string{"foo", "bar"},
+			paths:   []string{"test", "test2"},
+		},
 	}
 	for tokens, blueprint := range tests {
 		var p = &parser{
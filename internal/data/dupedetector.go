@@ -19,21 +19,36 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"sync"
 )
 
 var DupeDetected = errors.New("Dupe detected")
 
-type DupeDetector map[string]bool
+// DupeDetector remembers the digests of registered streams. It is safe for
+// concurrent use.
+type DupeDetector struct {
+	mu   sync.Mutex
+	sums map[string]bool
+}
+
+func NewDupeDetector() *DupeDetector {
+	return &DupeDetector{sums: make(map[string]bool)}
+}
 
-func (r DupeDetector) Register(stream io.Reader, hash hash.Hash) error {
+// Hashes stream with hash and registers its digest. Returns DupeDetected if
+// the digest was already registered. The hash must not be shared with
+// concurrent callers.
+func (r *DupeDetector) Register(stream io.Reader, hash hash.Hash) error {
 	hash.Reset()
 	if _, err := io.Copy(hash, stream); err != nil {
 		return err
 	}
 	sum := fmt.Sprintf("%x", hash.Sum(nil))
-	if r[sum] {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	if r.sums[sum] {
 		return DupeDetected
 	}
-	r[sum] = true
+	r.sums[sum] = true
 	return nil
 }
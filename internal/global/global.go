@@ -14,14 +14,58 @@
 
 package global
 
+import (
+	"sync/atomic"
+)
+
 const (
 	ExitSuccess ProgramExitCode = iota
 	ExitHardError
 	ExitSoftError
+	ExitMismatch //At least one file did not match its stored checksum
 )
 
 const BufSize = 1048576 //Default buffer size is 1 MiB
 
 type ProgramExitCode int
 
-var ExitCode ProgramExitCode = ExitSuccess
+var exitCode atomic.Int64
+
+// Returns the exit code of the program.
+func ExitCode() ProgramExitCode {
+	return ProgramExitCode(exitCode.Load())
+}
+
+// Sets the exit code of the program, safe for concurrent use. A code never
+// replaces one of higher precedence, so a mismatch reported by verify is not
+// hidden by a later soft error.
+func SetExitCode(code ProgramExitCode) {
+	for {
+		current := exitCode.Load()
+		if ProgramExitCode(current).precedence() >= code.precedence() {
+			return
+		}
+		if exitCode.CompareAndSwap(current, int64(code)) {
+			return
+		}
+	}
+}
+
+// Resets the exit code to ExitSuccess.
+func ResetExitCode() {
+	exitCode.Store(int64(ExitSuccess))
+}
+
+// Returns the precedence of the exit code, hard errors win over mismatches,
+// mismatches win over soft errors.
+func (r ProgramExitCode) precedence() int {
+	switch r {
+	case ExitHardError:
+		return 3
+	case ExitMismatch:
+		return 2
+	case ExitSoftError:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,289 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package hashcache caches the checksums of files, so unchanged files don't
+// have to be read again.
+//
+// A file is identified by its device and inode number, its size and its
+// modification time. The cache is an append-only log in JSON Lines format,
+// every line holds the identity of a file, the hash algorithm, the checksum
+// and the path the file was hashed at. Later lines supersede earlier ones.
+// Malformed lines, like those left behind by an interrupted write, are
+// ignored and removed by Prune.
+package hashcache
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Identity of a file's content for a hash algorithm.
+type Key struct {
+	Dev   uint64      `json:"d"`
+	Ino   uint64      `json:"i"`
+	Size  int64       `json:"s"`
+	Mtime int64       `json:"m"` //Modification time in nanoseconds since the epoch
+	Algo  hashes.Algo `json:"a"`
+}
+
+// Returns the key of the file described by info. Returns false if the
+// platform doesn't provide device and inode numbers.
+func KeyOf(info fs.FileInfo, algo hashes.Algo) (Key, bool) {
+	dev, ino, ok := fileID(info)
+	if !ok {
+		return Key{}, false
+	}
+	return Key{Dev: dev, Ino: ino, Size: info.Size(), Mtime: info.ModTime().UnixNano(), Algo: algo}, true
+}
+
+// A single line of the cache log.
+type entry struct {
+	Key
+	Path     string `json:"p"`
+	Checksum string `json:"h"`
+}
+
+// Cache of checksums, backed by a log file. It is safe for concurrent use.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[Key]*entry
+	f       *os.File
+	path    string
+}
+
+// Loads the cache at path and opens it for appending, creates it if it
+// doesn't exist.
+func Open(path string) (*Cache, error) {
+	log, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	//Terminate a truncated last line, so it doesn't swallow the next entry
+	if log.unterminated {
+		if _, err := f.Write([]byte{'\n'}); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Failed to update hash cache %s: %s", path, err)
+		}
+	}
+	return &Cache{
+		entries: log.entries,
+		f:       f,
+		path:    path,
+	}, nil
+}
+
+// Returns the cached checksum for key.
+func (r *Cache) Lookup(key Key) ([]byte, bool) {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	e := r.entries[key]
+	if e == nil {
+		return nil, false
+	}
+	sum, err := hex.DecodeString(e.Checksum)
+	return sum, err == nil
+}
+
+// Caches sum as the checksum for key, path is the file's current path.
+func (r *Cache) Store(key Key, path string, sum []byte) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	e := &entry{Key: key, Path: abs, Checksum: hex.EncodeToString(sum)}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	if old := r.entries[key]; old != nil && old.Path == e.Path && old.Checksum == e.Checksum {
+		return nil
+	}
+	r.entries[key] = e
+	//Append the whole line with a single write, so lines of concurrent
+	//xtagger runs don't interleave
+	if _, err := r.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("Failed to update hash cache %s: %s", r.path, err)
+	}
+	return nil
+}
+
+// Closes the cache.
+func (r *Cache) Close() error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	return r.f.Close()
+}
+
+// Content of a cache log.
+type logContent struct {
+	entries      map[Key]*entry //Current entries
+	lines        int            //Number of lines, including malformed ones
+	malformed    int            //Number of malformed lines
+	unterminated bool           //True if the last line lacks its newline
+}
+
+// Reads the cache at path. Malformed lines are skipped. Returns no entries
+// if the cache does not exist.
+func load(path string) (*logContent, error) {
+	log := &logContent{entries: make(map[Key]*entry)}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return log, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	//A reader instead of a scanner, so overlong garbage lines don't abort loading
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			log.lines++
+			log.unterminated = line[len(line)-1] != '\n'
+			if e := parseLine(line); e != nil {
+				log.entries[e.Key] = e
+			} else {
+				log.malformed++
+			}
+		}
+		if err == io.EOF {
+			return log, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Parses a line of the cache log, returns nil if the line is malformed.
+func parseLine(line []byte) *entry {
+	e := new(entry)
+	if err := json.Unmarshal(line, e); err != nil {
+		return nil
+	}
+	//Size panics for unknown algorithms
+	if err := e.Algo.Validate(); err != nil {
+		return nil
+	}
+	if _, err := hex.DecodeString(e.Checksum); err != nil || len(e.Checksum) != e.Algo.Size()*2 {
+		return nil
+	}
+	return e
+}
+
+// Returns true if the file of e is gone or changed since it was hashed.
+func (r *entry) stale() bool {
+	info, err := os.Stat(r.Path)
+	if err != nil {
+		return true
+	}
+	key, ok := KeyOf(info, r.Algo)
+	return !ok || key != r.Key
+}
+
+// Statistics of a cache.
+type Statistics struct {
+	Entries    int                 //Number of current entries
+	Superseded int                 //Number of log lines superseded by later ones
+	Malformed  int                 //Number of malformed log lines
+	Stale      int                 //Number of entries whose file is gone or changed
+	ByAlgo     map[hashes.Algo]int //Number of current entries per hash algorithm
+	Size       int64               //Size of the log in bytes
+}
+
+// Returns statistics of the cache at path.
+func Stats(path string) (*Statistics, error) {
+	log, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	stats := &Statistics{
+		Entries:    len(log.entries),
+		Superseded: log.lines - log.malformed - len(log.entries),
+		Malformed:  log.malformed,
+		ByAlgo:     make(map[hashes.Algo]int),
+	}
+	for _, e := range log.entries {
+		stats.ByAlgo[e.Algo]++
+		if e.stale() {
+			stats.Stale++
+		}
+	}
+	if info, err := os.Stat(path); err == nil {
+		stats.Size = info.Size()
+	}
+	return stats, nil
+}
+
+// Rewrites the cache at path without stale and superseded entries and
+// malformed lines. Returns the number of kept entries and the number of
+// removed log lines.
+func Prune(path string) (kept, removed int, err error) {
+	log, err := load(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".xtagger-cache-*.tmp")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	w := bufio.NewWriter(f)
+	for _, e := range log.entries {
+		if e.stale() {
+			continue
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return 0, 0, err
+		}
+		w.Write(line)
+		if err := w.WriteByte('\n'); err != nil {
+			return 0, 0, err
+		}
+		kept++
+	}
+	if err := w.Flush(); err != nil {
+		return 0, 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, 0, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return 0, 0, err
+	}
+	return kept, log.lines - kept, nil
+}
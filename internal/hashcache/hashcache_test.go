@@ -0,0 +1,186 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hashcache
+
+import (
+	"bytes"
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func createFile(t *testing.T, path, content string) Key {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	key, ok := KeyOf(info, hashes.SHA256)
+	if !ok {
+		t.Skip("Platform does not provide inode numbers")
+	}
+	return key
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	cachePath := filepath.Join(dir, "cache")
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	keyA, keyB := createFile(t, a, "a"), createFile(t, b, "b")
+	sumA, sumB := bytes.Repeat([]byte{0xa}, 32), bytes.Repeat([]byte{0xb}, 32)
+	cache, err := Open(cachePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, ok := cache.Lookup(keyA); ok {
+		t.Error("Empty cache returned a checksum")
+	}
+	for key, sum := range map[Key][]byte{keyA: sumA, keyB: sumB} {
+		path := a
+		if key == keyB {
+			path = b
+		}
+		//The second store is a no-op
+		for range 2 {
+			if err := cache.Store(key, path, sum); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+		}
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	//Reopen cache
+	cache, err = Open(cachePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if sum, ok := cache.Lookup(keyA); !ok || !bytes.Equal(sum, sumA) {
+		t.Errorf("Expected checksum %x, got %x", sumA, sum)
+	}
+	//Modify a, its old key is stale now
+	keyA2 := createFile(t, a, "aa")
+	if _, ok := cache.Lookup(keyA2); ok {
+		t.Error("Cache returned a checksum for a modified file")
+	}
+	if err := cache.Store(keyA2, a, sumB); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := os.Remove(b); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	stats, err := Stats(cachePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if stats.Entries != 3 || stats.Stale != 2 || stats.Superseded != 0 || stats.ByAlgo[hashes.SHA256] != 3 {
+		t.Errorf("Unexpected statistics: %+v", stats)
+	}
+	kept, removed, err := Prune(cachePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if kept != 1 || removed != 2 {
+		t.Errorf("Expected 1 kept and 2 removed entries, got %d and %d", kept, removed)
+	}
+	cache, err = Open(cachePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer cache.Close()
+	if sum, ok := cache.Lookup(keyA2); !ok || !bytes.Equal(sum, sumB) {
+		t.Errorf("Expected checksum %x, got %x", sumB, sum)
+	}
+	if _, ok := cache.Lookup(keyA); ok {
+		t.Error("Pruned entry is still cached")
+	}
+}
+
+func TestMalformedLines(t *testing.T) {
+	dir := t.TempDir()
+	cachePath := filepath.Join(dir, "cache")
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	keyA, keyB := createFile(t, a, "a"), createFile(t, b, "b")
+	sumA, sumB := bytes.Repeat([]byte{0xa}, 32), bytes.Repeat([]byte{0xb}, 32)
+	cache, err := Open(cachePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := cache.Store(keyA, a, sumA); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	//Append malformed lines, the last one is truncated like by an interrupted write
+	garbage := []string{
+		"not json\n",
+		`{"d":1,"i":2,"s":3,"m":4,"a":"SHA256","p":"/a","h":"abc"}` + "\n",
+		`{"d":1,"i":2,"s":3,"m":4,"a":"UNKNOWN","p":"/a","h":""}` + "\n",
+		`{"d":1,"i":2,"s":3,"m":4,"p":"/a","h":"ab"}` + "\n",
+		`{"d":1,"i":2,"s":3,"m":4,"a":null,"p":"/a","h":"ab"}` + "\n",
+		strings.Repeat("x", 1<<17) + "\n",
+		`{"d":1,"i":2,"s":3,"m"`,
+	}
+	f, err := os.OpenFile(cachePath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, line := range garbage {
+		if _, err := f.WriteString(line); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	f.Close()
+	//Malformed lines are skipped, new entries start on a new line
+	cache, err = Open(cachePath)
+	if err != nil {
+		t.Fatalf("Failed to open cache with malformed lines: %s", err)
+	}
+	if sum, ok := cache.Lookup(keyA); !ok || !bytes.Equal(sum, sumA) {
+		t.Errorf("Expected checksum %x, got %x", sumA, sum)
+	}
+	if err := cache.Store(keyB, b, sumB); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	stats, err := Stats(cachePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if stats.Entries != 2 || stats.Malformed != len(garbage) || stats.Superseded != 0 {
+		t.Errorf("Unexpected statistics: %+v", stats)
+	}
+	//Prune removes the malformed lines
+	kept, removed, err := Prune(cachePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if kept != 2 || removed != len(garbage) {
+		t.Errorf("Expected 2 kept and %d removed lines, got %d and %d", len(garbage), kept, removed)
+	}
+	if stats, err := Stats(cachePath); err != nil || stats.Malformed != 0 || stats.Entries != 2 {
+		t.Errorf("Unexpected statistics after pruning: %+v, %v", stats, err)
+	}
+}
@@ -15,33 +15,104 @@
 package hashes
 
 import (
-	"crypto/sha256"
 	"errors"
 	"fmt"
-	"golang.org/x/crypto/ripemd160"
-	"golang.org/x/crypto/sha3"
 	"hash"
-)
-
-const (
-	SHA256    Algo = "SHA256"    //SHA 256
-	RIPEMD160      = "RIPEMD160" //RIPEMD 160
-	SHA3256        = "SHA3256"   //SHA3 256
+	"slices"
+	"strings"
+	"sync"
 )
 
 // Represents a name of a particular hashing algorithm at a particluar length.
 type Algo string
 
+// Describes a hashing algorithm for Register.
+type Descriptor struct {
+	Name    Algo             //Canonical name, stored in records
+	Aliases []string         //Alternative names accepted by ParseAlgo
+	Size    int              //Digest size in bytes
+	New     func() hash.Hash //Constructor
+	ID      byte             //Stable numeric ID for compact encodings, must never change once assigned
+	BSDName string           //Name in BSD-style checksum files
+}
+
+var registry = struct {
+	sync.RWMutex
+	algos map[Algo]*Descriptor   //Descriptors by canonical name
+	names map[string]*Descriptor //Descriptors by lower case name and aliases
+	ids   map[byte]*Descriptor   //Descriptors by ID
+}{
+	algos: make(map[Algo]*Descriptor),
+	names: make(map[string]*Descriptor),
+	ids:   make(map[byte]*Descriptor),
+}
+
+// Makes a hashing algorithm available. Names and aliases are matched case
+// insensitively. Register panics if the descriptor is incomplete or if its
+// name, one of its aliases or its ID is already taken.
+func Register(desc Descriptor) {
+	if desc.Name == "" || desc.Size < 1 || desc.New == nil || desc.ID == 0 {
+		panic(fmt.Errorf("Incomplete descriptor for hashing algorithm %q", desc.Name))
+	}
+	if desc.BSDName == "" {
+		desc.BSDName = string(desc.Name)
+	}
+	desc.Aliases = slices.Clone(desc.Aliases)
+	registry.Lock()
+	defer registry.Unlock()
+	names := append([]string{string(desc.Name)}, desc.Aliases...)
+	for _, name := range names {
+		if _, ok := registry.names[strings.ToLower(name)]; ok {
+			panic(fmt.Errorf("Hashing algorithm %q is already registered", name))
+		}
+	}
+	if other, ok := registry.ids[desc.ID]; ok {
+		panic(fmt.Errorf("ID %d of hashing algorithm %q is already taken by %q", desc.ID, desc.Name, other.Name))
+	}
+	for _, name := range names {
+		registry.names[strings.ToLower(name)] = &desc
+	}
+	registry.algos[desc.Name] = &desc
+	registry.ids[desc.ID] = &desc
+}
+
+// Returns the descriptors of all registered algorithms, sorted by name.
+func Registered() []Descriptor {
+	registry.RLock()
+	defer registry.RUnlock()
+	descs := make([]Descriptor, 0, len(registry.algos))
+	for _, desc := range registry.algos {
+		descs = append(descs, *desc)
+	}
+	slices.SortFunc(descs, func(a, b Descriptor) int {
+		return strings.Compare(string(a.Name), string(b.Name))
+	})
+	return descs
+}
+
+func lookup(r Algo) (*Descriptor, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	desc, ok := registry.algos[r]
+	return desc, ok
+}
+
+// Returns the descriptor of the receiver, panics if the receiver is not
+// a supported algorithm.
+func (r Algo) descriptor() *Descriptor {
+	if desc, ok := lookup(r); ok {
+		return desc
+	}
+	panic(fmt.Errorf("Receiver has an invalid value: %q", r))
+}
+
 // Returns the Algo corresponding to Name, returns an error if Name
 // does not represent an existing Algo
 func ParseAlgo(Name string) (Algo, error) {
-	switch Name {
-	case "SHA256", "sha256", "SHA_256", "sha_256":
-		return SHA256, nil
-	case "RIPEMD160", "ripemd160", "ripemd_160", "RIPEMD_160":
-		return RIPEMD160, nil
-	case "SHA3256", "sha3256", "SHA3_256", "sha3_256":
-		return SHA3256, nil
+	registry.RLock()
+	defer registry.RUnlock()
+	if desc, ok := registry.names[strings.ToLower(Name)]; ok {
+		return desc.Name, nil
 	}
 	return "", fmt.Errorf("Unknown hashing algorithm %q", Name)
 }
@@ -49,15 +120,30 @@ func ParseAlgo(Name string) (Algo, error) {
 // Returns a usable hash.Hash interface. If the receiver is not a valid name for
 // a supported hash function, the method panics.
 func (r Algo) New() hash.Hash {
-	switch r {
-	case SHA256:
-		return sha256.New()
-	case RIPEMD160:
-		return ripemd160.New()
-	case SHA3256:
-		return sha3.New256()
+	return r.descriptor().New()
+}
+
+// Returns the digest size of the algorithm in bytes. If the receiver is not
+// a valid name for a supported hash function, the method panics.
+func (r Algo) Size() int {
+	return r.descriptor().Size
+}
+
+// Returns the stable numeric ID of the algorithm for compact encodings.
+// If the receiver is not a valid name for a supported hash function,
+// the method panics.
+func (r Algo) ID() byte {
+	return r.descriptor().ID
+}
+
+// Returns the Algo with the numeric ID id.
+func AlgoByID(id byte) (Algo, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	if desc, ok := registry.ids[id]; ok {
+		return desc.Name, nil
 	}
-	panic(fmt.Errorf("Receiver has an invalid value: %q", r))
+	return "", fmt.Errorf("Unknown hashing algorithm ID %d", id)
 }
 
 func (r *Algo) UnmarshalText(text []byte) error {
@@ -73,8 +159,7 @@ func (r *Algo) UnmarshalText(text []byte) error {
 }
 
 func (r Algo) Validate() error {
-	switch r {
-	case SHA256, RIPEMD160, SHA3256:
+	if _, ok := lookup(r); ok {
 		return nil
 	}
 	return fmt.Errorf("Unsupported hashing algorithm %q", r)
@@ -0,0 +1,112 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hashes
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Digests of "abc" for every supported algorithm.
+var abcDigests = map[Algo]string{
+	SHA256:     "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+	RIPEMD160:  "8eb208f7e05d987a9b044a8e98c6b087f15a0bfc",
+	SHA3256:    "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532",
+	BLAKE2B256: "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319",
+	BLAKE2B512: "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+	BLAKE3:     "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85",
+	SHA512:     "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+	SHA1:       "a9993e364706816aba3e25717850c26c9cd0d89d",
+	XXH64:      "44bc2cf5ad770999",
+}
+
+func TestAlgoDigests(t *testing.T) {
+	if len(abcDigests) != len(Registered()) {
+		t.Errorf("Expected test vectors for %d algorithms, got %d", len(Registered()), len(abcDigests))
+	}
+	for algo, expected := range abcDigests {
+		h := algo.New()
+		h.Write([]byte("abc"))
+		sum := h.Sum(nil)
+		if len(sum) != algo.Size() {
+			t.Errorf("%s: Expected a digest of %d bytes, got %d", algo, algo.Size(), len(sum))
+		}
+		if got := fmt.Sprintf("%x", sum); got != expected {
+			t.Errorf("%s: Expected digest %s, got %s", algo, expected, got)
+		}
+	}
+}
+
+func TestAlgoIDs(t *testing.T) {
+	seen := make(map[byte]Algo)
+	for _, desc := range Registered() {
+		algo := desc.Name
+		id := algo.ID()
+		if other, ok := seen[id]; ok {
+			t.Errorf("%s and %s share ID %d", algo, other, id)
+		}
+		seen[id] = algo
+		if got, err := AlgoByID(id); err != nil || got != algo {
+			t.Errorf("ID %d: Expected %s, got %q (%v)", id, algo, got, err)
+		}
+	}
+	if _, err := AlgoByID(0); err == nil {
+		t.Error("Expected an error for ID 0")
+	}
+}
+
+func TestParseAlgo(t *testing.T) {
+	samples := map[string]Algo{
+		"SHA256":      SHA256,
+		"sha_256":     SHA256,
+		"ripemd_160":  RIPEMD160,
+		"sha3_256":    SHA3256,
+		"SHA3-256":    SHA3256,
+		"blake2b":     BLAKE2B512,
+		"BLAKE2b-256": BLAKE2B256,
+		"xxhash64":    XXH64,
+	}
+	for name, expected := range samples {
+		if algo, err := ParseAlgo(name); err != nil || algo != expected {
+			t.Errorf("%q: Expected %s, got %q (%v)", name, expected, algo, err)
+		}
+	}
+	for _, name := range []string{"", "MD5", "SHA 256"} {
+		if _, err := ParseAlgo(name); err == nil {
+			t.Errorf("%q: Expected an error", name)
+		}
+	}
+}
+
+func TestNegativeRegister(t *testing.T) {
+	samples := map[string]Descriptor{
+		"incomplete":     {Name: "TEST", Size: 1, ID: 200},
+		"duplicate name": {Name: "TEST", Aliases: []string{"sha256"}, Size: 1, New: SHA256.New, ID: 200},
+		"duplicate ID":   {Name: "TEST", Size: 1, New: SHA256.New, ID: SHA256.ID()},
+	}
+	for name, desc := range samples {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: Expected Register to panic", name)
+				}
+			}()
+			Register(desc)
+		}()
+	}
+	if err := Algo("TEST").Validate(); err == nil {
+		t.Error("Failed registrations must not leave an algorithm behind")
+	}
+}
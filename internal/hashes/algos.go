@@ -0,0 +1,69 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hashes
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ripemd160"
+	"golang.org/x/crypto/sha3"
+	"hash"
+	"lukechampine.com/blake3"
+)
+
+const (
+	SHA256     Algo = "SHA256"     //SHA 256
+	RIPEMD160       = "RIPEMD160"  //RIPEMD 160
+	SHA3256         = "SHA3256"    //SHA3 256
+	BLAKE2B256      = "BLAKE2B256" //BLAKE2b 256
+	BLAKE2B512      = "BLAKE2B512" //BLAKE2b 512
+	BLAKE3          = "BLAKE3"     //BLAKE3 256
+	SHA512          = "SHA512"     //SHA 512
+	SHA1            = "SHA1"       //SHA 1, for interoperability with legacy checksums only
+	XXH64           = "XXH64"      //xxHash 64, not cryptographic
+)
+
+func init() {
+	Register(Descriptor{Name: SHA256, Aliases: []string{"SHA_256"}, Size: sha256.Size, New: sha256.New, ID: 1, BSDName: "SHA256"})
+	Register(Descriptor{Name: RIPEMD160, Aliases: []string{"RIPEMD_160", "RMD160"}, Size: ripemd160.Size, New: ripemd160.New, ID: 2, BSDName: "RMD160"})
+	Register(Descriptor{Name: SHA3256, Aliases: []string{"SHA3_256", "SHA3-256"}, Size: 32, New: sha3.New256, ID: 3, BSDName: "SHA3-256"})
+	Register(Descriptor{Name: BLAKE2B256, Aliases: []string{"BLAKE2B_256", "BLAKE2b-256"}, Size: blake2b.Size256, New: newBLAKE2B256, ID: 4, BSDName: "BLAKE2b-256"})
+	Register(Descriptor{Name: BLAKE2B512, Aliases: []string{"BLAKE2B_512", "BLAKE2b"}, Size: blake2b.Size, New: newBLAKE2B512, ID: 5, BSDName: "BLAKE2b"})
+	Register(Descriptor{Name: BLAKE3, Aliases: []string{"B3"}, Size: 32, New: newBLAKE3, ID: 6, BSDName: "BLAKE3"})
+	Register(Descriptor{Name: SHA512, Aliases: []string{"SHA_512"}, Size: sha512.Size, New: sha512.New, ID: 7, BSDName: "SHA512"})
+	Register(Descriptor{Name: SHA1, Aliases: []string{"SHA_1"}, Size: sha1.Size, New: sha1.New, ID: 8, BSDName: "SHA1"})
+	Register(Descriptor{Name: XXH64, Aliases: []string{"XXHASH64"}, Size: 8, New: newXXH64, ID: 9, BSDName: "XXH64"})
+}
+
+func newBLAKE2B256() hash.Hash {
+	h, _ := blake2b.New256(nil) //Only fails for oversized keys
+	return h
+}
+
+func newBLAKE2B512() hash.Hash {
+	h, _ := blake2b.New512(nil) //Only fails for oversized keys
+	return h
+}
+
+func newBLAKE3() hash.Hash {
+	return blake3.New(32, nil)
+}
+
+func newXXH64() hash.Hash {
+	return xxhash.New()
+}
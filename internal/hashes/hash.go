@@ -15,6 +15,7 @@
 package hashes
 
 import (
+	"errors"
 	"github.com/jwdev42/xtagger/internal/global"
 	"hash"
 	"io"
@@ -61,3 +62,40 @@ func MultiHash(src io.Reader, hashMap map[Algo]hash.Hash) error {
 	}
 	return nil
 }
+
+// Hashes src as a whole and in consecutive blocks of blockSize bytes in a
+// single pass. Returns the digest of src and the digest of each block.
+func HashBlocks(src io.Reader, algo Algo, blockSize int64) (sum []byte, blocks [][]byte, err error) {
+	if blockSize < 1 {
+		return nil, nil, errors.New("Block size must be positive")
+	}
+	whole := algo.New()
+	block := algo.New()
+	buf := make([]byte, global.BufSize)
+	var filled int64 //Bytes written to the current block
+	for {
+		n, readErr := src.Read(buf)
+		for data := buf[:n]; len(data) > 0; {
+			chunk := data[:min(int64(len(data)), blockSize-filled)]
+			whole.Write(chunk)
+			block.Write(chunk)
+			filled += int64(len(chunk))
+			data = data[len(chunk):]
+			if filled == blockSize {
+				blocks = append(blocks, block.Sum(nil))
+				block.Reset()
+				filled = 0
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, nil, readErr
+		}
+	}
+	if filled > 0 {
+		blocks = append(blocks, block.Sum(nil))
+	}
+	return whole.Sum(nil), blocks, nil
+}
@@ -0,0 +1,148 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hashes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Returns the name of the algorithm in BSD-style checksum files, as
+// written by the --tag option of GNU coreutils and by BSD's checksum tools.
+func (r Algo) BSDName() string {
+	if desc, ok := lookup(r); ok {
+		return desc.BSDName
+	}
+	return string(r)
+}
+
+// Returns the Algo for a name used in BSD-style checksum files.
+func ParseBSDName(name string) (Algo, error) {
+	for _, desc := range Registered() {
+		if desc.BSDName == name {
+			return desc.Name, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown hashing algorithm %q", name)
+}
+
+// Escapes path like GNU coreutils do. Returns the escaped path and true if
+// the line has to be prefixed with a backslash.
+func escapeSumPath(path string) (string, bool) {
+	if !strings.ContainsAny(path, "\\\n\r") {
+		return path, false
+	}
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(path), true
+}
+
+func unescapeSumPath(path string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] != '\\' {
+			b.WriteByte(path[i])
+			continue
+		}
+		i++
+		if i >= len(path) {
+			return "", errors.New("Unterminated escape sequence")
+		}
+		switch path[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			return "", fmt.Errorf("Unknown escape sequence \\%c", path[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// Returns a line of a GNU-style checksum file as written by sha256sum,
+// without line terminator.
+func FormatGNUSumLine(checksum, path string) string {
+	escaped, prefix := escapeSumPath(path)
+	if prefix {
+		return "\\" + checksum + "  " + escaped
+	}
+	return checksum + "  " + escaped
+}
+
+// Returns a line of a BSD-style checksum file as written by sha256sum --tag,
+// without line terminator.
+func FormatBSDSumLine(algo Algo, checksum, path string) string {
+	escaped, prefix := escapeSumPath(path)
+	line := fmt.Sprintf("%s (%s) = %s", algo.BSDName(), escaped, checksum)
+	if prefix {
+		return "\\" + line
+	}
+	return line
+}
+
+// Parses a line of a GNU-style or BSD-style checksum file. The format is
+// detected automatically. As GNU-style lines don't name the algorithm,
+// gnuAlgo is returned for them. The checksum is returned in lower case.
+func ParseSumLine(line string, gnuAlgo Algo) (algo Algo, checksum, path string, err error) {
+	line = strings.TrimSuffix(line, "\r")
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+	if sep := strings.Index(line, " "); sep > 0 && isHex(line[:sep]) {
+		//GNU style: CHECKSUM  PATH or CHECKSUM *PATH
+		if len(line) < sep+3 || line[sep+1] != ' ' && line[sep+1] != '*' {
+			return "", "", "", fmt.Errorf("Malformed checksum line %q", line)
+		}
+		algo = gnuAlgo
+		checksum = line[:sep]
+		path = line[sep+2:]
+	} else {
+		//BSD style: ALGO (PATH) = CHECKSUM
+		open := strings.Index(line, " (")
+		closing := strings.LastIndex(line, ") = ")
+		if open < 1 || closing < open {
+			return "", "", "", fmt.Errorf("Malformed checksum line %q", line)
+		}
+		if algo, err = ParseBSDName(line[:open]); err != nil {
+			return "", "", "", err
+		}
+		path = line[open+2 : closing]
+		checksum = line[closing+4:]
+	}
+	if !isHex(checksum) {
+		return "", "", "", fmt.Errorf("Malformed checksum %q", checksum)
+	}
+	if escaped {
+		if path, err = unescapeSumPath(path); err != nil {
+			return "", "", "", err
+		}
+	}
+	return algo, strings.ToLower(checksum), path, nil
+}
+
+func isHex(s string) bool {
+	if len(s) < 1 {
+		return false
+	}
+	for _, ch := range s {
+		if !(ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f' || ch >= 'A' && ch <= 'F') {
+			return false
+		}
+	}
+	return true
+}
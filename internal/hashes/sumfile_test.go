@@ -0,0 +1,86 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hashes
+
+import (
+	"testing"
+)
+
+func TestSumLineRoundTrip(t *testing.T) {
+	samples := []struct {
+		algo     Algo
+		checksum string
+		path     string
+	}{
+		{SHA256, "368b97b0b055910d97d284f834cbf1f8d5dec95b70576c8aedf6361e6a7bbc63", "a/b.txt"},
+		{RIPEMD160, "9c1185a5c5e9fc54612808977ee8f548b2258d31", "with (parens) = and spaces"},
+		{SHA3256, "a7ffc6f8bf1ed76651c14756a061d662f580ff4de43b49fa82d80a4b80f8434a", "back\\slash\nnewline"},
+	}
+	for i, sample := range samples {
+		lines := map[string]string{
+			"gnu": FormatGNUSumLine(sample.checksum, sample.path),
+			"bsd": FormatBSDSumLine(sample.algo, sample.checksum, sample.path),
+		}
+		for style, line := range lines {
+			algo, checksum, path, err := ParseSumLine(line, sample.algo)
+			if err != nil {
+				t.Errorf("Sample %d (%s): Failed to parse %q: %s", i, style, line, err)
+				continue
+			}
+			if algo != sample.algo || checksum != sample.checksum || path != sample.path {
+				t.Errorf("Sample %d (%s): Expected %s %s %q, got %s %s %q", i, style, sample.algo, sample.checksum, sample.path, algo, checksum, path)
+			}
+		}
+	}
+}
+
+func TestParseSumLine(t *testing.T) {
+	samples := map[string][3]string{
+		"ABCDEF01  file":             {string(SHA256), "abcdef01", "file"},
+		"abcdef01 *binary file":      {string(SHA256), "abcdef01", "binary file"},
+		"RMD160 (x) = 0123":          {string(RIPEMD160), "0123", "x"},
+		"SHA3-256 (a) = b) = 0123\r": {string(SHA3256), "0123", "a) = b"},
+		"\\abcd  a\\\\b\\nc":         {string(SHA256), "abcd", "a\\b\nc"},
+	}
+	for line, expected := range samples {
+		algo, checksum, path, err := ParseSumLine(line, SHA256)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %s", line, err)
+			continue
+		}
+		if got := [3]string{string(algo), checksum, path}; got != expected {
+			t.Errorf("Line %q: Expected %q, got %q", line, expected, got)
+		}
+	}
+}
+
+func TestNegativeParseSumLine(t *testing.T) {
+	samples := []string{
+		"",
+		"abcdef01",
+		"abcdef01 file",
+		"xyz  file",
+		"MD4 (file) = abcd",
+		"SHA256 (file) = xyz",
+		"SHA256 file = abcd",
+		"\\abcd  a\\tb",
+		"\\abcd  a\\",
+	}
+	for _, line := range samples {
+		if _, _, _, err := ParseSumLine(line, SHA256); err == nil {
+			t.Errorf("Line %q: Expected an error", line)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hashes
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/jwdev42/xtagger/internal/global"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Result of hashing a file as a tree of fixed-size chunks. Each leaf is the
+// digest of one chunk, the root is the digest of the chunk size followed by
+// all leaves.
+type Tree struct {
+	ChunkSize int64    //Size of the chunks in bytes
+	Root      []byte   //Digest of the chunk size and all leaves
+	Leaves    [][]byte //Digest of each chunk
+}
+
+// Hashes the first size bytes of src in chunks of chunkSize bytes with
+// algorithm algo. Chunks are hashed concurrently by up to GOMAXPROCS
+// goroutines.
+func TreeHash(src io.ReaderAt, size int64, algo Algo, chunkSize int64) (*Tree, error) {
+	if chunkSize < 1 {
+		return nil, errors.New("Chunk size must be positive")
+	}
+	if size < 0 {
+		return nil, errors.New("Size cannot be negative")
+	}
+	chunks := (size + chunkSize - 1) / chunkSize
+	leaves := make([][]byte, chunks)
+	var next atomic.Int64
+	var once sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	for range min(int64(runtime.GOMAXPROCS(0)), chunks) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hash := algo.New()
+			buf := make([]byte, global.BufSize)
+			for {
+				i := next.Add(1) - 1
+				if i >= chunks {
+					return
+				}
+				offset := i * chunkSize
+				hash.Reset()
+				if _, err := io.CopyBuffer(hash, io.NewSectionReader(src, offset, min(chunkSize, size-offset)), buf); err != nil {
+					once.Do(func() { firstErr = err })
+					next.Store(chunks) //Stop all workers
+					return
+				}
+				leaves[i] = hash.Sum(nil)
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return &Tree{
+		ChunkSize: chunkSize,
+		Root:      TreeRoot(algo, chunkSize, leaves),
+		Leaves:    leaves,
+	}, nil
+}
+
+// Returns the root digest of a tree with the given leaves.
+func TreeRoot(algo Algo, chunkSize int64, leaves [][]byte) []byte {
+	hash := algo.New()
+	hash.Write(binary.BigEndian.AppendUint64(nil, uint64(chunkSize)))
+	for _, leaf := range leaves {
+		hash.Write(leaf)
+	}
+	return hash.Sum(nil)
+}
@@ -0,0 +1,111 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hashes
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+)
+
+func TestTreeHash(t *testing.T) {
+	content := bytes.Repeat([]byte("xtagger"), 1000)
+	for _, chunkSize := range []int64{1, 100, 4096, 7000, 10000} {
+		tree, err := TreeHash(bytes.NewReader(content), int64(len(content)), SHA256, chunkSize)
+		if err != nil {
+			t.Fatalf("Chunk size %d: Unexpected error: %s", chunkSize, err)
+		}
+		expected := (int64(len(content)) + chunkSize - 1) / chunkSize
+		if int64(len(tree.Leaves)) != expected {
+			t.Errorf("Chunk size %d: Expected %d leaves, got %d", chunkSize, expected, len(tree.Leaves))
+		}
+		for i, leaf := range tree.Leaves {
+			start := int64(i) * chunkSize
+			hash := SHA256.New()
+			hash.Write(content[start:min(start+chunkSize, int64(len(content)))])
+			if !bytes.Equal(leaf, hash.Sum(nil)) {
+				t.Errorf("Chunk size %d: Leaf %d does not match", chunkSize, i)
+			}
+		}
+		if !bytes.Equal(tree.Root, TreeRoot(SHA256, chunkSize, tree.Leaves)) {
+			t.Errorf("Chunk size %d: Root does not match leaves", chunkSize)
+		}
+	}
+}
+
+func TestTreeHashIsDeterministic(t *testing.T) {
+	content := bytes.Repeat([]byte("xtagger"), 10000)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	sequential, err := TreeHash(bytes.NewReader(content), int64(len(content)), BLAKE3, 1000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	runtime.GOMAXPROCS(8)
+	parallel, err := TreeHash(bytes.NewReader(content), int64(len(content)), BLAKE3, 1000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !bytes.Equal(sequential.Root, parallel.Root) {
+		t.Error("Root depends on the number of workers")
+	}
+	//Chunk size is part of the root
+	other, err := TreeHash(bytes.NewReader(content), int64(len(content)), BLAKE3, 2000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if bytes.Equal(sequential.Root, other.Root) {
+		t.Error("Roots of different chunk sizes must differ")
+	}
+}
+
+func TestNegativeTreeHash(t *testing.T) {
+	if _, err := TreeHash(bytes.NewReader(nil), 0, SHA256, 0); err == nil {
+		t.Error("Expected an error for chunk size 0")
+	}
+	if _, err := TreeHash(bytes.NewReader(nil), -1, SHA256, 1); err == nil {
+		t.Error("Expected an error for negative size")
+	}
+}
+
+func TestHashBlocks(t *testing.T) {
+	content := bytes.Repeat([]byte("xtagger"), 1000)
+	whole := SHA256.New()
+	whole.Write(content)
+	for _, blockSize := range []int64{1, 100, 7000, 10000} {
+		sum, blocks, err := HashBlocks(bytes.NewReader(content), SHA256, blockSize)
+		if err != nil {
+			t.Fatalf("Block size %d: Unexpected error: %s", blockSize, err)
+		}
+		if !bytes.Equal(sum, whole.Sum(nil)) {
+			t.Errorf("Block size %d: Digest of the whole content does not match", blockSize)
+		}
+		//Block digests are the leaves of a tree of the same size
+		tree, err := TreeHash(bytes.NewReader(content), int64(len(content)), SHA256, blockSize)
+		if err != nil {
+			t.Fatalf("Block size %d: Unexpected error: %s", blockSize, err)
+		}
+		if len(blocks) != len(tree.Leaves) {
+			t.Fatalf("Block size %d: Expected %d blocks, got %d", blockSize, len(tree.Leaves), len(blocks))
+		}
+		for i := range blocks {
+			if !bytes.Equal(blocks[i], tree.Leaves[i]) {
+				t.Errorf("Block size %d: Digest of block %d does not match", blockSize, i)
+			}
+		}
+	}
+	if _, blocks, err := HashBlocks(bytes.NewReader(nil), SHA256, 1); err != nil || len(blocks) != 0 {
+		t.Errorf("Expected no blocks for empty content, got %d: %v", len(blocks), err)
+	}
+}
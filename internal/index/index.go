@@ -0,0 +1,193 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package index maintains a local index of tagged files, which allows to
+// query records without walking the filesystem.
+//
+// The index is an append-only log in JSON Lines format. Every line holds
+// the absolute path of a file and its complete Attribute at the time of
+// writing. Later lines supersede earlier ones, a line without records
+// removes the file from the index. Malformed lines, like those left behind by
+// an interrupted write, are skipped.
+package index
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/record"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A single line of the index log.
+type entry struct {
+	Path    string           `json:"p"`
+	Records record.Attribute `json:"r,omitempty"`
+}
+
+// Index appends updates to an index log. It is safe for concurrent use.
+type Index struct {
+	mu      sync.Mutex
+	f       *os.File
+	path    string //Path of the index
+	tmpPath string //Path of the temporary file if the index is rebuilt, empty otherwise
+}
+
+// Opens the index at path for appending, creates it if it doesn't exist.
+func Open(path string) (*Index, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	//Terminate a truncated last line, so it doesn't swallow the next entry
+	if err := terminate(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Failed to update index %s: %s", path, err)
+	}
+	return &Index{
+		f:    f,
+		path: path,
+	}, nil
+}
+
+// Creates an empty index that replaces the index at path once it is closed.
+// The index at path is left untouched if Abort is called instead of Close.
+func Create(path string) (*Index, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), ".xtagger-index-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &Index{
+		f:       f,
+		path:    path,
+		tmpPath: f.Name(),
+	}, nil
+}
+
+// Records attr as the current Attribute of the file at path. A nil or empty
+// attr removes the file from the index.
+func (r *Index) Update(path string, attr record.Attribute) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(&entry{Path: abs, Records: attr})
+	if err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	//Append the whole line with a single write, so lines of concurrent
+	//xtagger runs don't interleave
+	if _, err := r.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("Failed to update index %s: %s", r.path, err)
+	}
+	return nil
+}
+
+// Closes the index. A rebuilt index replaces the previous one.
+func (r *Index) Close() error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	if err := r.f.Sync(); err != nil {
+		r.f.Close()
+		return err
+	}
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if r.tmpPath != "" {
+		return os.Rename(r.tmpPath, r.path)
+	}
+	return nil
+}
+
+// Discards a rebuilt index, the previous index stays in place. For an index
+// opened by Open, Abort is equivalent to Close.
+func (r *Index) Abort() error {
+	if r.tmpPath == "" {
+		return r.Close()
+	}
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	r.f.Close()
+	return os.Remove(r.tmpPath)
+}
+
+// Appends a newline to File f if its last byte is not a newline.
+func terminate(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = f.Write([]byte{'\n'})
+	return err
+}
+
+// Reads the index at path and returns the current Attribute of every
+// indexed file, keyed by absolute path, and the number of malformed lines,
+// which are skipped. Returns an empty map if the index does not exist.
+func Load(path string) (files map[string]record.Attribute, malformed int, err error) {
+	files = make(map[string]record.Attribute)
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return files, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	//A reader instead of a scanner, so overlong lines don't abort loading
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if e := parseLine(line); e == nil {
+				malformed++
+			} else if len(e.Records) == 0 {
+				delete(files, e.Path)
+			} else {
+				files[e.Path] = e.Records
+			}
+		}
+		if err == io.EOF {
+			return files, malformed, nil
+		} else if err != nil {
+			return nil, 0, fmt.Errorf("Failed to read index %s: %s", path, err)
+		}
+	}
+}
+
+// Parses a line of the index log, returns nil if the line is malformed.
+func parseLine(line []byte) *entry {
+	e := new(entry)
+	if err := json.Unmarshal(line, e); err != nil || e.Path == "" {
+		return nil
+	}
+	if len(e.Records) > 0 && e.Records.Validate() != nil {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,188 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package index
+
+import (
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"github.com/jwdev42/xtagger/internal/record"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sampleAttribute(timestamp int64) record.Attribute {
+	return record.Attribute{
+		"backup": &record.Record{
+			Checksum:  "1f2946e2fd7d0be6c4295c1ed828f0ff4aec21e89df898f9efbaddbe445c5c7c",
+			HashAlgo:  hashes.SHA256,
+			Timestamp: timestamp,
+			Valid:     true,
+		},
+	}
+}
+
+func TestIndexReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index")
+	//Loading a missing index yields an empty index
+	files, _, err := Load(path)
+	if err != nil || len(files) != 0 {
+		t.Fatalf("Expected empty index, got %v: %v", files, err)
+	}
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open index: %s", err)
+	}
+	updates := []struct {
+		path string
+		attr record.Attribute
+	}{
+		{"/a", sampleAttribute(1)},
+		{"/b", sampleAttribute(2)},
+		{"/a", sampleAttribute(3)}, //Supersedes first update
+		{"/b", nil},                //Removes /b
+		{"/c", sampleAttribute(4)},
+		{"/c", record.Attribute{}}, //Removes /c
+	}
+	for _, update := range updates {
+		if err := idx.Update(update.path, update.attr); err != nil {
+			t.Fatalf("Failed to update index: %s", err)
+		}
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Failed to close index: %s", err)
+	}
+	files, _, err = Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load index: %s", err)
+	}
+	if len(files) != 1 || files["/a"] == nil || files["/a"]["backup"].Timestamp != 3 {
+		t.Errorf("Unexpected index content: %v", files)
+	}
+	//Appending keeps earlier entries
+	idx, err = Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open index: %s", err)
+	}
+	if err := idx.Update("/d", sampleAttribute(5)); err != nil {
+		t.Fatalf("Failed to update index: %s", err)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Failed to close index: %s", err)
+	}
+	if files, _, err = Load(path); err != nil || len(files) != 2 {
+		t.Errorf("Expected 2 indexed files after append, got %v: %v", files, err)
+	}
+}
+
+func TestIndexRebuild(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index")
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open index: %s", err)
+	}
+	if err := idx.Update("/old", sampleAttribute(1)); err != nil {
+		t.Fatalf("Failed to update index: %s", err)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Failed to close index: %s", err)
+	}
+	//An aborted rebuild leaves the index untouched
+	idx, err = Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create index: %s", err)
+	}
+	if err := idx.Update("/new", sampleAttribute(2)); err != nil {
+		t.Fatalf("Failed to update index: %s", err)
+	}
+	if err := idx.Abort(); err != nil {
+		t.Fatalf("Failed to abort index: %s", err)
+	}
+	if files, _, err := Load(path); err != nil || len(files) != 1 || files["/old"] == nil {
+		t.Errorf("Aborted rebuild modified the index: %v: %v", files, err)
+	}
+	//A completed rebuild replaces the index
+	idx, err = Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create index: %s", err)
+	}
+	if err := idx.Update("/new", sampleAttribute(2)); err != nil {
+		t.Fatalf("Failed to update index: %s", err)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Failed to close index: %s", err)
+	}
+	if files, _, err := Load(path); err != nil || len(files) != 1 || files["/new"] == nil {
+		t.Errorf("Rebuild did not replace the index: %v: %v", files, err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Temporary files left after rebuild: %v: %v", entries, err)
+	}
+}
+
+func TestMalformedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index")
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open index: %s", err)
+	}
+	if err := idx.Update("/a", sampleAttribute(1)); err != nil {
+		t.Fatalf("Failed to update index: %s", err)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Failed to close index: %s", err)
+	}
+	//Append malformed lines, the last one is truncated like by an interrupted write
+	garbage := []string{
+		"{\n",
+		`{"r":{}}` + "\n",
+		`{"p":"/a","r":{"test":null}}` + "\n",
+		`{"p":"/a","r":{"test":{"c":"00","h":"SHA256","t":0,"v":true}}}` + "\n",
+		strings.Repeat("x", 1<<17) + "\n",
+		`{"p":"/b","r"`,
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, line := range garbage {
+		if _, err := f.WriteString(line); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	f.Close()
+	//Malformed lines are skipped, new entries start on a new line
+	idx, err = Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open index with malformed lines: %s", err)
+	}
+	if err := idx.Update("/b", sampleAttribute(2)); err != nil {
+		t.Fatalf("Failed to update index: %s", err)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Failed to close index: %s", err)
+	}
+	files, malformed, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load index with malformed lines: %s", err)
+	}
+	if malformed != len(garbage) {
+		t.Errorf("Expected %d malformed lines, got %d", len(garbage), malformed)
+	}
+	if len(files) != 2 || files["/a"] == nil || files["/b"] == nil {
+		t.Errorf("Unexpected index content: %v", files)
+	}
+}
@@ -0,0 +1,213 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"github.com/jwdev42/xtagger/internal/record"
+	"github.com/jwdev42/xtagger/internal/softerrors"
+	"github.com/jwdev42/xtagger/internal/xio"
+	"github.com/jwdev42/xtagger/internal/xio/filesystem"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+const (
+	defaultArchiveBlockSize = 10240 //Default record size of tar
+	tarBlockSize            = 512   //Size of a tar header block
+)
+
+// Streams files as tar archive to a file or device.
+type archiver struct {
+	path      string      //Absolute path of the archive
+	out       os.FileInfo //FileInfo of the archive, used to exclude it from itself
+	tw        *tar.Writer
+	blockSize int64
+	quota     int64 //Maximum archive size in bytes, 0 if unlimited
+	written   int64 //Bytes written to the archive, including headers and padding
+}
+
+// Runs command archive.
+func runArchive(opts *filesystem.Context) error {
+	//The archiver enforces the quota itself as it has to account for tar headers
+	opts.SetQuota(filesystem.QuotaDisabled, 0)
+	blockSize := commandLine.BlockSize()
+	if blockSize == 0 {
+		blockSize = defaultArchiveBlockSize
+	}
+	path, err := filepath.Abs(commandLine.Destination())
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	outInfo, err := out.Stat()
+	if err != nil {
+		return err
+	}
+	if quota := commandLine.SizeQuota(); quota > 0 && quota < archiveSize(0, blockSize) {
+		return fmt.Errorf("Size limit of %d bytes is too small for an archive with a block size of %d bytes", quota, blockSize)
+	}
+	bw := xio.NewBlockWriter(out, int(blockSize))
+	a := &archiver{
+		path:      path,
+		out:       outInfo,
+		tw:        tar.NewWriter(bw),
+		blockSize: blockSize,
+		quota:     commandLine.SizeQuota(),
+	}
+	runErr := runRoots(opts, func(root string) filesystem.FileExaminer {
+		return func(parent string, info fs.FileInfo) error {
+			return a.addFile(root, parent, info)
+		}
+	})
+	//Terminate the archive even after an error to keep its contents readable
+	if err := a.tw.Close(); err != nil {
+		return errors.Join(runErr, err)
+	}
+	if _, err := bw.Finalize(); err != nil {
+		return errors.Join(runErr, err)
+	}
+	if err := out.Close(); err != nil {
+		return errors.Join(runErr, err)
+	}
+	return runErr
+}
+
+// Returns the size of an archive with a payload of written bytes after
+// the end-of-archive marker and the block padding have been added.
+func archiveSize(written, blockSize int64) int64 {
+	const endOfArchive = 2 * tarBlockSize
+	size := written + endOfArchive
+	if remainder := size % blockSize; remainder > 0 {
+		size += blockSize - remainder
+	}
+	return size
+}
+
+// Returns the bytes hdr and its payload will occupy in the archive.
+func entrySize(hdr *tar.Header) (int64, error) {
+	//Let a throwaway tar.Writer encode the header to get its exact size
+	counter := new(xio.CountingWriter)
+	if err := tar.NewWriter(counter).WriteHeader(hdr); err != nil {
+		return 0, err
+	}
+	size := counter.Count() + hdr.Size
+	if remainder := hdr.Size % tarBlockSize; remainder > 0 {
+		size += tarBlockSize - remainder
+	}
+	return size, nil
+}
+
+func (r *archiver) addFile(root, parent string, info fs.FileInfo) error {
+	path := filepath.Join(parent, info.Name())
+	name := commandLine.Names()[0]
+	algo := commandLine.FlagHash()
+	//Open and lock file
+	f, err := record.OpenLocked(path)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	if os.SameFile(stat, r.out) {
+		slog.Debug("Skipping the archive itself", "path", path)
+		return nil
+	}
+	if !stat.Mode().IsRegular() {
+		return softerrors.Errorf("Cannot archive %s: Not a regular file", path)
+	}
+	//Load attribute
+	attr, err := record.FLoadAttribute(f)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	//Check if a record with the designated name already exists
+	if attr.Exists(name) {
+		return softerrors.Consume(fmt.Errorf("Record \"%s\" already exists for path \"%s\"", name, path))
+	}
+	//Create tar header
+	member, err := copyDestination(root, "", path)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	hdr, err := tar.FileInfoHeader(stat, "")
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	hdr.Name = filepath.ToSlash(member)
+	//Check quota
+	size, err := entrySize(hdr)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	if r.quota > 0 && archiveSize(r.written+size, r.blockSize) > r.quota {
+		if commandLine.FlagQuotaContinue() {
+			slog.Debug("archive: File exceeds quota, skipping...", "path", path)
+			return nil
+		}
+		slog.Debug("archive: File exceeds quota, aborting...", "path", path)
+		return fs.SkipAll
+	}
+	//Write file to archive, errors from here on corrupt the archive and are therefore fatal
+	slog.Debug("Archiving file", "path", path, "member", hdr.Name)
+	if err := r.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	hash := algo.New()
+	written, err := hashes.HashCopy(r.tw, io.LimitReader(f, hdr.Size), hash)
+	if err != nil {
+		return fmt.Errorf("Could not archive %s: %s", path, err)
+	}
+	if written != hdr.Size {
+		return fmt.Errorf("Could not archive %s: File shrunk while being archived", path)
+	}
+	r.written += size
+	//Create record
+	rec := record.NewRecord()
+	rec.Checksum = fmt.Sprintf("%x", hash.Sum(nil))
+	rec.HashAlgo = algo
+	rec.Valid = true
+	rec.Archive = r.path
+	rec.SetFileMeta(stat)
+	attr[name] = rec
+	//Save attribute
+	if err := attr.FStore(f); err != nil {
+		return softerrors.Consume(err)
+	}
+	if err := updateIndex(path, attr); err != nil {
+		return err
+	}
+	slog.Info("Archived file", "path", path, "archive", r.path, "checksum", rec.Checksum, "algorithm", rec.HashAlgo)
+	//Print path if print0 is active
+	if commandLine.FlagPrint0() {
+		if _, err := printMe.Print0(path); err != nil {
+			return softerrors.Consume(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"errors"
+	"github.com/jwdev42/xtagger/internal/cli"
+	"github.com/jwdev42/xtagger/internal/hashcache"
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"github.com/jwdev42/xtagger/internal/softerrors"
+	"hash"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+)
+
+// Hash cache consulted before hashing whole files, nil if no cache is in use.
+var hashCache *hashcache.Cache
+
+// Opens the hash cache set on the command line for commands that hash
+// files to update records. Returns a function that closes the cache.
+func openCache() (closeCache func() error, err error) {
+	noop := func() error { return nil }
+	hashCache = nil
+	path := commandLine.FlagCache()
+	if path == "" {
+		return noop, nil
+	}
+	switch commandLine.Command() {
+	case cli.CommandTag, cli.CommandInvalidate, cli.CommandRevalidate:
+	default:
+		return noop, nil
+	}
+	hashCache, err = hashcache.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return hashCache.Close, nil
+}
+
+// Returns true if checksums may be taken from the hash cache. Commands
+// invalidate and revalidate check files against their records, so they
+// always read them, which also catches corruption that left the file's
+// metadata untouched.
+func cacheLookups() bool {
+	return commandLine.Command() == cli.CommandTag && !commandLine.FlagRehash()
+}
+
+// Hashes f as a whole with every algorithm in algos. Checksums of unchanged
+// files are taken from the hash cache if one is in use and cacheLookups
+// allows it. New checksums are added to the cache.
+func hashWhole(f *os.File, algos ...hashes.Algo) (map[hashes.Algo][]byte, error) {
+	sums := make(map[hashes.Algo][]byte)
+	hashMap := make(map[hashes.Algo]hash.Hash)
+	keys := make(map[hashes.Algo]hashcache.Key)
+	if hashCache != nil {
+		stat, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		for _, algo := range algos {
+			key, ok := hashcache.KeyOf(stat, algo)
+			if !ok {
+				continue
+			}
+			keys[algo] = key
+			if !cacheLookups() {
+				continue
+			}
+			if sum, ok := hashCache.Lookup(key); ok {
+				slog.Debug("Checksum taken from hash cache", "path", f.Name(), "algorithm", algo)
+				sums[algo] = sum
+			}
+		}
+	}
+	for _, algo := range algos {
+		if sums[algo] == nil {
+			hashMap[algo] = algo.New()
+		}
+	}
+	if len(hashMap) == 0 {
+		return sums, nil
+	}
+	if err := hashes.MultiHash(f, hashMap); err != nil {
+		return nil, err
+	}
+	for algo, hash := range hashMap {
+		sums[algo] = hash.Sum(nil)
+	}
+	if len(keys) == 0 {
+		return sums, nil
+	}
+	//Only cache checksums if the file didn't change while it was hashed
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	for algo := range hashMap {
+		key, ok := keys[algo]
+		if !ok {
+			continue
+		}
+		if after, _ := hashcache.KeyOf(stat, algo); after != key {
+			slog.Warn("File changed while it was hashed", "path", f.Name())
+			break
+		}
+		if err := softerrors.Consume(hashCache.Store(key, f.Name(), sums[algo])); err != nil {
+			return nil, err
+		}
+	}
+	return sums, nil
+}
+
+// Runs command cache.
+func runCache() error {
+	path := commandLine.FlagCache()
+	if path == "" {
+		return errors.New("Command cache requires option -cache")
+	}
+	switch commandLine.CacheAction() {
+	case cli.CacheActionPrune:
+		kept, removed, err := hashcache.Prune(path)
+		if err != nil {
+			return err
+		}
+		_, err = printMe.Printf("Kept %d entries, removed %d stale, superseded or malformed lines\n", kept, removed)
+		return err
+	case cli.CacheActionStats:
+		stats, err := hashcache.Stats(path)
+		if err != nil {
+			return err
+		}
+		if _, err := printMe.Printf("entries\t%d\nstale\t%d\nsuperseded\t%d\nmalformed\t%d\nbytes\t%d\n", stats.Entries, stats.Stale, stats.Superseded, stats.Malformed, stats.Size); err != nil {
+			return err
+		}
+		for _, algo := range slices.Sorted(maps.Keys(stats.ByAlgo)) {
+			if _, err := printMe.Printf("%s\t%d\n", algo, stats.ByAlgo[algo]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	panic("You're not supposed to be here")
+}
@@ -0,0 +1,95 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/record"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Fails the test if the checksum of record name of the file at path is not
+// the SHA256 checksum of content.
+func expectChecksum(t *testing.T, path, name, content string) {
+	t.Helper()
+	attr, err := record.LoadAttribute(path)
+	if err != nil {
+		t.Fatalf("Could not load attribute of %s: %s", path, err)
+	}
+	if rec := attr[name]; rec == nil {
+		t.Errorf("%s: Record %s is missing", path, name)
+	} else if expected := fmt.Sprintf("%x", sha256.Sum256([]byte(content))); rec.Checksum != expected {
+		t.Errorf("%s: Expected checksum of record %s to be %s, got %s", path, name, expected, rec.Checksum)
+	}
+}
+
+func TestHashCache(t *testing.T) {
+	dir := createFiles(t, map[string]string{"a": "content a", "b": "content b"})
+	a := filepath.Join(dir, "a")
+	cache := filepath.Join(t.TempDir(), "cache")
+	runXtagger(t, "-cache", cache, "tag", "as", "first", "for", dir)
+	//Replace the content of a without changing its size and modification time
+	stat, err := os.Stat(a)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	writeFile(t, a, "content x")
+	if err := os.Chtimes(a, stat.ModTime(), stat.ModTime()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	//The cache provides the checksum of the unchanged file
+	runXtagger(t, "-cache", cache, "tag", "as", "second", "for", dir)
+	expectChecksum(t, a, "second", "content a")
+	//Invalidate reads the file and notices the corruption
+	output := runXtagger(t, "-cache", cache, "invalidate", "all", "for", dir)
+	if !strings.Contains(output, "INVALIDATED 2 "+a+"\n") {
+		t.Errorf("Unexpected output: %q", output)
+	}
+	expectReason(t, a, "first", record.ReasonCorrupted)
+	//Invalidate refreshed the cache
+	runXtagger(t, "-cache", cache, "tag", "as", "third", "for", dir)
+	expectChecksum(t, a, "third", "content x")
+	//-rehash bypasses the cache and refreshes it
+	writeFile(t, a, "content y")
+	if err := os.Chtimes(a, stat.ModTime(), stat.ModTime()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	runXtagger(t, "-cache", cache, "-rehash", "tag", "as", "fourth", "for", dir)
+	expectChecksum(t, a, "fourth", "content y")
+	runXtagger(t, "-cache", cache, "tag", "as", "fifth", "for", dir)
+	expectChecksum(t, a, "fifth", "content y")
+	//Statistics and pruning
+	if output := runXtagger(t, "-cache", cache, "cache", "stats"); output != "entries\t2\nstale\t0\nsuperseded\t2\nmalformed\t0\nbytes\t"+fileSize(t, cache)+"\nSHA256\t2\n" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+	if err := os.Remove(a); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if output := runXtagger(t, "-cache", cache, "cache", "prune"); output != "Kept 1 entries, removed 3 stale, superseded or malformed lines\n" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+}
+
+func fileSize(t *testing.T, path string) string {
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return fmt.Sprint(stat.Size())
+}
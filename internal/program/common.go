@@ -15,14 +15,16 @@
 package program
 
 import (
-	"context"
 	"crypto/sha256"
+	"fmt"
 	"github.com/jwdev42/xtagger/internal/cli"
 	"github.com/jwdev42/xtagger/internal/data"
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"github.com/jwdev42/xtagger/internal/record"
 	"github.com/jwdev42/xtagger/internal/xio/filesystem"
 	"github.com/jwdev42/xtagger/internal/xio/printer"
-	"io/fs"
-	"sync"
+	"os"
+	"slices"
 )
 
 var commandLine *cli.CommandLine
@@ -30,9 +32,16 @@ var printMe *printer.Printer
 
 func createContext(detectProcessedFiles bool) *filesystem.Context {
 	var opts = new(filesystem.Context)
+	opts.Ignore = record.IsStorageFile
+	opts.Filter = commandLine.FlagFilter()
 	if commandLine.FlagFollowSymlinks() {
 		opts.SymlinkMode = filesystem.SymlinksRejectNone
 	}
+	//Commands that don't run on runWithOptionalMP collect state in their
+	//FileExaminer, which is only safe for an ordered walk
+	opts.Walkers = commandLine.FlagWalkers()
+	opts.Ordered = true
+	opts.Sorted = commandLine.FlagSorted()
 	if quota := commandLine.SizeQuota(); quota > 0 {
 		if commandLine.FlagQuotaContinue() {
 			opts.SetQuota(filesystem.QuotaSkip, quota)
@@ -40,25 +49,67 @@ func createContext(detectProcessedFiles bool) *filesystem.Context {
 		opts.SetQuota(filesystem.QuotaCutoff, quota)
 	}
 	if detectProcessedFiles {
-		opts.DupeDetector = make(data.DupeDetector)
-		opts.DetectorHash = sha256.New()
+		opts.DupeDetector = data.NewDupeDetector()
+		opts.DetectorHash = sha256.New
 	}
 	return opts
 }
 
-func wrapFileExaminer(ctx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup, errs chan<- error, payload filesystem.FileExaminer) filesystem.FileExaminer {
-	return func(parent string, info fs.FileInfo) error {
-		if err := ctx.Err(); err != nil {
-			return err
+// Hashing parameters of a record. Records with equal parameters share
+// their checksum computation.
+type hashSpec struct {
+	algo      hashes.Algo
+	chunkSize int64 //0 if the file is hashed as a whole
+}
+
+func specOf(rec *record.Record) hashSpec {
+	return hashSpec{algo: rec.HashAlgo, chunkSize: rec.ChunkSize}
+}
+
+// Hashes of a file, keyed by hashing parameters. Hashes of files hashed as a
+// whole are trees without leaves.
+type fileSums map[hashSpec]*hashes.Tree
+
+// Returns true if the checksum of rec matches the file.
+func (r fileSums) matches(rec *record.Record) bool {
+	tree := r[specOf(rec)]
+	return tree != nil && fmt.Sprintf("%x", tree.Root) == rec.Checksum
+}
+
+// Hashes f for every set of hashing parameters used by the records in attr.
+// f is read once for all records hashed as a whole and once per chunk size.
+// Checksums of files hashed as a whole may come from the hash cache.
+func checksums(f *os.File, attr record.Attribute) (fileSums, error) {
+	sums := make(fileSums)
+	algos := make([]hashes.Algo, 0)
+	for _, rec := range attr {
+		if rec.ChunkSize == 0 && !slices.Contains(algos, rec.HashAlgo) {
+			algos = append(algos, rec.HashAlgo)
+		}
+	}
+	if len(algos) > 0 {
+		wholeSums, err := hashWhole(f, algos...)
+		if err != nil {
+			return nil, err
+		}
+		for algo, sum := range wholeSums {
+			sums[hashSpec{algo: algo}] = &hashes.Tree{Root: sum}
+		}
+	}
+	for _, rec := range attr {
+		spec := specOf(rec)
+		if spec.chunkSize == 0 || sums[spec] != nil {
+			continue
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		tree, err := hashes.TreeHash(f, stat.Size(), spec.algo, spec.chunkSize)
+		if err != nil {
+			return nil, err
 		}
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := payload(parent, info); err != nil {
-				cancel()
-				errs <- err
-			}
-		}()
-		return nil
+		sums[spec] = tree
 	}
+	return sums, nil
 }
@@ -0,0 +1,204 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"github.com/jwdev42/xtagger/internal/record"
+	"github.com/jwdev42/xtagger/internal/softerrors"
+	"github.com/jwdev42/xtagger/internal/xio/filesystem"
+	"hash"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Runs command copy. Every path argument is copied into the destination
+// directory under its base name, like cp -r does.
+func runCopy(opts *filesystem.Context) error {
+	dest, err := filepath.Abs(commandLine.Destination())
+	if err != nil {
+		return err
+	}
+	//Refuse to copy a tree into itself
+	for _, path := range commandLine.Paths() {
+		root, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if dest == root || strings.HasPrefix(dest, root+string(filepath.Separator)) {
+			return fmt.Errorf("Destination %s is inside of source %s", dest, path)
+		}
+	}
+	return runRoots(opts, func(root string) filesystem.FileExaminer {
+		return func(parent string, info fs.FileInfo) error {
+			return copyFile(root, dest, parent, info)
+		}
+	})
+}
+
+// Returns the path within directory dest that corresponds to path,
+// which was reached from path argument root.
+func copyDestination(root, dest, path string) (string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(filepath.Dir(absRoot), absPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dest, rel), nil
+}
+
+func copyFile(root, dest, parent string, info fs.FileInfo) error {
+	path := filepath.Join(parent, info.Name())
+	name := commandLine.Names()[0]
+	algo := commandLine.FlagHash()
+	target, err := copyDestination(root, dest, path)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	//Open and lock source file
+	src, err := record.OpenLocked(path)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	if !stat.Mode().IsRegular() {
+		return softerrors.Errorf("Cannot copy %s: Not a regular file", path)
+	}
+	//Load attribute
+	attr, err := record.FLoadAttribute(src)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	//Process tag constraint
+	if skipByTagConstraint(attr) {
+		return nil
+	}
+	//Check if a record with the designated name already exists
+	if attr.Exists(name) {
+		return softerrors.Consume(fmt.Errorf("Record \"%s\" already exists for path \"%s\"", name, path))
+	}
+	//Create destination file
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return softerrors.Consume(err)
+	}
+	dst, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE|os.O_EXCL, stat.Mode().Perm())
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	defer dst.Close()
+	//Removes the incomplete destination file, then consumes err
+	fail := func(err error) error {
+		dst.Close()
+		if err := os.Remove(target); err != nil {
+			slog.Error("Could not remove incomplete copy", "path", target, "error", err)
+		}
+		return softerrors.Consume(err)
+	}
+	//Copy and hash file
+	slog.Debug("Copying file", "path", path, "destination", target)
+	hash := algo.New()
+	if _, err := hashes.HashCopy(dst, src, hash); err != nil {
+		return fail(fmt.Errorf("Could not copy %s to %s: %s", path, target, err))
+	}
+	checksum := fmt.Sprintf("%x", hash.Sum(nil))
+	//Re-read destination file from disk and compare hashes
+	if err := dst.Sync(); err != nil {
+		return fail(err)
+	}
+	verifyHash := algo.New()
+	if err := hashFromDisk(target, verifyHash); err != nil {
+		return fail(err)
+	}
+	if fmt.Sprintf("%x", verifyHash.Sum(nil)) != checksum {
+		return fail(fmt.Errorf("Checksum of copy %s does not match source %s", target, path))
+	}
+	//Create record
+	rec := record.NewRecord()
+	rec.Checksum = checksum
+	rec.HashAlgo = algo
+	rec.Valid = true
+	attr[name] = rec
+	//Preserve modification time
+	if err := os.Chtimes(target, stat.ModTime(), stat.ModTime()); err != nil {
+		return fail(err)
+	}
+	//Save attribute on both files, each with its own metadata
+	dstStat, err := dst.Stat()
+	if err != nil {
+		return fail(err)
+	}
+	dstAttr := make(record.Attribute, len(attr))
+	for carriedName, carried := range attr {
+		carried = carried.Copy()
+		if carriedName == name || (carried.HasFileMeta() && !carried.FileMetaChanged(stat)) {
+			//The metadata described the source as it was copied
+			carried.SetFileMeta(dstStat)
+		} else {
+			//The source changed since the record was created, the copy's metadata can't tell
+			carried.Size, carried.MTime, carried.Device, carried.Inode = 0, 0, 0, 0
+		}
+		dstAttr[carriedName] = carried
+	}
+	if err := dstAttr.FStore(dst); err != nil {
+		return fail(err)
+	}
+	if err := updateIndex(target, dstAttr); err != nil {
+		return err
+	}
+	rec.SetFileMeta(stat)
+	if err := attr.FStore(src); err != nil {
+		return softerrors.Consume(err)
+	}
+	if err := updateIndex(path, attr); err != nil {
+		return err
+	}
+	slog.Info("Copied file", "path", path, "destination", target, "checksum", rec.Checksum, "algorithm", rec.HashAlgo)
+	//Print path if print0 is active
+	if commandLine.FlagPrint0() {
+		if _, err := printMe.Print0(path); err != nil {
+			return softerrors.Consume(err)
+		}
+	}
+	return nil
+}
+
+// Hashes the file at path after evicting it from the page cache, so the
+// content that reached the storage device is hashed. The file must be synced.
+func hashFromDisk(path string, h hash.Hash) error {
+	f, err := filesystem.OpenReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := filesystem.DropCache(f); err != nil {
+		return fmt.Errorf("Could not drop cached pages of %s: %s", path, err)
+	}
+	return hashes.Hash(f, h)
+}
@@ -0,0 +1,50 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"github.com/jwdev42/xtagger/internal/record"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopyCarriedRecords(t *testing.T) {
+	dir := createFiles(t, map[string]string{"src/a": "content a", "src/b": "content b"})
+	a, b := filepath.Join(dir, "src", "a"), filepath.Join(dir, "src", "b")
+	dest := filepath.Join(dir, "dest")
+	runXtagger(t, "tag", "as", "first", "for", a, b)
+	//Modify b after tagging, its record no longer describes it
+	writeFile(t, b, "modified b")
+	runXtagger(t, "copy", "as", "second", "to", dest, "for", a, b)
+	copyA, copyB := filepath.Join(dest, "a"), filepath.Join(dest, "b")
+	//Corrupt the copy of a: same size, restored modification time
+	info, err := os.Stat(copyA)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	writeFile(t, copyA, "content x")
+	if err := os.Chtimes(copyA, time.Time{}, info.ModTime()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	//Carried records hold the copy's metadata
+	runXtagger(t, "invalidate", "all", "for", copyA, copyB)
+	expectReason(t, copyA, "first", record.ReasonCorrupted)
+	expectReason(t, copyA, "second", record.ReasonCorrupted)
+	//Records that didn't describe the source lose their metadata
+	expectReason(t, copyB, "first", record.ReasonNone)
+	expectRecords(t, copyB, map[string]bool{"first": false, "second": true})
+}
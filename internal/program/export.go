@@ -0,0 +1,175 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/cli"
+	"github.com/jwdev42/xtagger/internal/record"
+	"github.com/jwdev42/xtagger/internal/softerrors"
+	"github.com/jwdev42/xtagger/internal/xio/filesystem"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Export files are JSON Lines files. The first line is an exportHeader,
+// every following line is an exportEntry.
+const (
+	exportFormat  = "xtagger-export"
+	exportVersion = 1
+)
+
+type exportHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+type exportEntry struct {
+	Path    string           `json:"path"`    //Slash-separated path relative to the exported path argument
+	Records record.Attribute `json:"records"` //Records of the file
+}
+
+// Runs command export.
+func runExport(opts *filesystem.Context) error {
+	if commandLine.FileFormat() != cli.FileFormatRecords {
+		return runExportChecksums(opts)
+	}
+	out, err := os.OpenFile(commandLine.Destination(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	outInfo, err := out.Stat()
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&exportHeader{Format: exportFormat, Version: exportVersion}); err != nil {
+		return err
+	}
+	runErr := runRoots(opts, func(root string) filesystem.FileExaminer {
+		return func(parent string, info fs.FileInfo) error {
+			return exportFile(enc, outInfo, root, parent, info)
+		}
+	})
+	if err := w.Flush(); err != nil {
+		return errors.Join(runErr, err)
+	}
+	return errors.Join(runErr, out.Close())
+}
+
+// Returns the slash-separated path of path relative to the path argument
+// root it was reached from. Files given as path argument are exported
+// under their base name.
+func exportPath(root, path string) (string, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return filepath.Base(path), nil
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func exportFile(enc *json.Encoder, out fs.FileInfo, root, parent string, info fs.FileInfo) error {
+	path := filepath.Join(parent, info.Name())
+	//Open file
+	f, err := os.Open(path)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	defer f.Close()
+	if stat, err := f.Stat(); err == nil && os.SameFile(stat, out) {
+		return nil //Skip the export file itself
+	}
+	//Load attribute
+	attr, err := record.FLoadAttribute(f)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	//Filter Attributes by name
+	if names := commandLine.Names(); names != nil {
+		attr = attr.FilterByName(names...)
+	}
+	if len(attr) < 1 {
+		return nil
+	}
+	rel, err := exportPath(root, path)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	if err := enc.Encode(&exportEntry{Path: rel, Records: attr}); err != nil {
+		return err
+	}
+	//Print path if print0 is active
+	if commandLine.FlagPrint0() {
+		if _, err := printMe.Print0(path); err != nil {
+			return softerrors.Consume(err)
+		}
+	}
+	return nil
+}
+
+// Reads the export file at path and calls fn for every entry.
+func readExportFile(path string, fn func(entry *exportEntry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	//Check header
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%s is empty", path)
+	}
+	header := new(exportHeader)
+	if err := json.Unmarshal(scanner.Bytes(), header); err != nil || header.Format != exportFormat {
+		return fmt.Errorf("%s is not an xtagger export file", path)
+	}
+	if header.Version != exportVersion {
+		return fmt.Errorf("Unsupported version %d of export file %s", header.Version, path)
+	}
+	//Read entries
+	for line := 2; scanner.Scan(); line++ {
+		entry := new(exportEntry)
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return fmt.Errorf("%s, line %d: %s", path, line, err)
+		}
+		if !filepath.IsLocal(filepath.FromSlash(entry.Path)) {
+			return fmt.Errorf("%s, line %d: Path %q is not a local path", path, line, entry.Path)
+		}
+		if err := entry.Records.Validate(); err != nil {
+			return fmt.Errorf("%s, line %d: %s", path, line, err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,31 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"strings"
+)
+
+// Runs command hashes list. Prints one line per supported hashing algorithm
+// with its name, digest size in bits and aliases.
+func listHashes() error {
+	for _, desc := range hashes.Registered() {
+		if _, err := printMe.Printf("%s\t%d\t%s\n", desc.Name, desc.Size*8, strings.Join(desc.Aliases, ", ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
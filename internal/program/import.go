@@ -0,0 +1,103 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"github.com/jwdev42/xtagger/internal/cli"
+	"github.com/jwdev42/xtagger/internal/record"
+	"github.com/jwdev42/xtagger/internal/softerrors"
+	"log/slog"
+	"path/filepath"
+)
+
+// Runs command import.
+func runImport() error {
+	if commandLine.FileFormat() == cli.FileFormatChecksums {
+		return runImportChecksums()
+	}
+	root := commandLine.Destination()
+	return readExportFile(commandLine.Source(), func(entry *exportEntry) error {
+		return importRecords(filepath.Join(root, filepath.FromSlash(entry.Path)), entry.Records)
+	})
+}
+
+// Adds the records of imported to the attribute of the file at path.
+// Records whose name already exists are skipped.
+func importRecords(path string, imported record.Attribute) error {
+	//Open and lock file
+	f, err := record.OpenLocked(path)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	//Load attribute
+	attr, err := record.FLoadAttribute(f)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	//Verify imported records against the file's content
+	var sums fileSums
+	if commandLine.Verified() {
+		slog.Debug("Hashing file", "path", path)
+		if sums, err = checksums(f, imported); err != nil {
+			return softerrors.Consume(err)
+		}
+	}
+	var modified bool
+	for name, rec := range imported {
+		if attr.Exists(name) {
+			slog.Warn("Record already exists, skipping", "path", path, "name", name)
+			continue
+		}
+		if commandLine.Verified() {
+			if !sums.matches(rec) {
+				slog.Warn("Checksum mismatch, skipping", "path", path, "name", name)
+				continue
+			}
+			//The file's content matches, so its current metadata belongs to the record
+			rec.SetFileMeta(stat)
+		} else {
+			//Metadata of the exported file can't be trusted for this file
+			rec.Size, rec.MTime, rec.Device, rec.Inode = 0, 0, 0, 0
+		}
+		//Block digests are not imported. The chunk size is kept, the checksum
+		//of a chunked record depends on it.
+		rec.BlockSize = 0
+		attr[name] = rec
+		modified = true
+		slog.Info("Imported record", "path", path, "name", name)
+	}
+	if !modified {
+		return nil
+	}
+	//Save attribute
+	if err := attr.FStore(f); err != nil {
+		return softerrors.Consume(err)
+	}
+	if err := updateIndex(path, attr); err != nil {
+		return err
+	}
+	//Print path if print0 is active
+	if commandLine.FlagPrint0() {
+		if _, err := printMe.Print0(path); err != nil {
+			return softerrors.Consume(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"errors"
+	"github.com/jwdev42/xtagger/internal/cli"
+	"github.com/jwdev42/xtagger/internal/index"
+	"github.com/jwdev42/xtagger/internal/record"
+	"github.com/jwdev42/xtagger/internal/softerrors"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Index to keep up to date, nil if no index is in use.
+var fileIndex *index.Index
+
+// Opens the index set on the command line for commands that modify records.
+// Returns a function that closes the index.
+func openIndex() (closeIndex func() error, err error) {
+	noop := func() error { return nil }
+	path := commandLine.FlagIndex()
+	if path == "" {
+		return noop, nil
+	}
+	switch commandLine.Command() {
+	case cli.CommandTag, cli.CommandUntag, cli.CommandInvalidate, cli.CommandRevalidate, cli.CommandCopy, cli.CommandArchive, cli.CommandImport:
+	default:
+		return noop, nil
+	}
+	fileIndex, err = index.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return fileIndex.Close, nil
+}
+
+// Records attr as the current Attribute of path if an index is in use.
+func updateIndex(path string, attr record.Attribute) error {
+	if fileIndex == nil {
+		return nil
+	}
+	return softerrors.Consume(fileIndex.Update(path, attr))
+}
+
+// Runs command index.
+func runIndex() error {
+	path := commandLine.FlagIndex()
+	if path == "" {
+		return errors.New("Command index requires option -index")
+	}
+	switch commandLine.IndexAction() {
+	case cli.IndexActionRebuild:
+		return rebuildIndex(path)
+	case cli.IndexActionQuery:
+		return queryIndex(path)
+	}
+	panic("You're not supposed to be here")
+}
+
+// Rebuilds the index at path from all tagged files in PATHS. Entries of files
+// outside of PATHS are kept.
+func rebuildIndex(path string) error {
+	files, err := loadIndex(path)
+	if err != nil {
+		return err
+	}
+	roots := make([]string, 0, len(commandLine.Paths()))
+	for _, root := range commandLine.Paths() {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, abs)
+	}
+	fileIndex, err = index.Create(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range slices.Sorted(maps.Keys(files)) {
+		if slices.ContainsFunc(roots, func(root string) bool { return isWithin(file, root) }) {
+			continue
+		}
+		if err := fileIndex.Update(file, files[file]); err != nil {
+			return errors.Join(err, fileIndex.Abort())
+		}
+	}
+	if err := run(createContext(true), indexFile); err != nil {
+		return errors.Join(err, fileIndex.Abort())
+	}
+	return fileIndex.Close()
+}
+
+// Returns true if path is root or lies below root. Both paths must be absolute.
+func isWithin(path, root string) bool {
+	return path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// Loads the index at path, warns about malformed lines.
+func loadIndex(path string) (map[string]record.Attribute, error) {
+	files, malformed, err := index.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if malformed > 0 {
+		slog.Warn("Skipped malformed lines of index", "path", path, "lines", malformed)
+	}
+	return files, nil
+}
+
+func indexFile(parent string, info fs.FileInfo) error {
+	path := filepath.Join(parent, info.Name())
+	//Open file
+	f, err := os.Open(path)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	defer f.Close()
+	//Load attribute
+	attr, err := record.FLoadAttribute(f)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	if len(attr) < 1 {
+		return nil
+	}
+	return updateIndex(path, attr)
+}
+
+// Prints all indexed files that match the query, sorted by path.
+func queryIndex(path string) error {
+	files, err := loadIndex(path)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	for _, path := range paths {
+		attr := files[path]
+		//Filter Attributes by name
+		if names := commandLine.Names(); names != nil {
+			attr = attr.FilterByName(names...)
+		}
+		//Filter Attributes by checksum
+		if checksum := commandLine.Checksum(); checksum != "" {
+			attr = attr.FilterByChecksum(checksum)
+		}
+		if len(attr) < 1 || !printConstraintMatches(attr) {
+			continue
+		}
+		if err := printEntry(attr, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndexRebuildKeepsOtherPaths(t *testing.T) {
+	dir := createFiles(t, map[string]string{
+		"a/1": "content 1",
+		"a/2": "content 2",
+		"b/3": "content 3",
+	})
+	idx := filepath.Join(t.TempDir(), "index")
+	one, two, three := filepath.Join(dir, "a", "1"), filepath.Join(dir, "a", "2"), filepath.Join(dir, "b", "3")
+	runXtagger(t, "-index", idx, "tag", "as", "test", "for", dir)
+	//Untag a file without updating the index, the rebuild drops it
+	runXtagger(t, "untag", "all", "for", two)
+	runXtagger(t, "-index", idx, "index", "rebuild", "for", filepath.Join(dir, "a"))
+	output := runXtagger(t, "-index", idx, "index", "query")
+	for path, indexed := range map[string]bool{one: true, two: false, three: true} {
+		if strings.Contains(output, path) != indexed {
+			t.Errorf("Expected %s to be indexed: %t, got %q", path, indexed, output)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"github.com/jwdev42/xtagger/internal/record"
+	"github.com/jwdev42/xtagger/internal/softerrors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+)
+
+// Rewrites the attribute of a file if it was stored with an older schema version.
+func migrateFile(parent string, info fs.FileInfo) error {
+	path := filepath.Join(parent, info.Name())
+	//Open and lock file
+	f, err := record.OpenLocked(path)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	defer f.Close()
+	//Load attribute, FLoadAttributeWithVersion upgrades it in memory
+	attr, version, err := record.FLoadAttributeWithVersion(f)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	if version == 0 || version == record.SchemaVersion {
+		//Nothing to do for untagged or up-to-date files
+		return nil
+	}
+	//Save attribute
+	if err := attr.FStore(f); err != nil {
+		return softerrors.Consume(err)
+	}
+	slog.Info("Migrated attribute", "path", path, "from", version, "to", record.SchemaVersion)
+	//Print path if print0 is active
+	if commandLine.FlagPrint0() {
+		if _, err := printMe.Print0(path); err != nil {
+			return softerrors.Consume(err)
+		}
+	}
+	return nil
+}
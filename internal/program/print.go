@@ -15,7 +15,6 @@
 package program
 
 import (
-	"fmt"
 	"github.com/jwdev42/xtagger/internal/cli"
 	"github.com/jwdev42/xtagger/internal/record"
 	"github.com/jwdev42/xtagger/internal/softerrors"
@@ -24,21 +23,23 @@ import (
 	"path/filepath"
 )
 
-func printFile(parent string, info fs.FileInfo) error {
-	print := func(attr record.Attribute, path string) error {
-		if commandLine.FlagPrint0() {
-			_, err := printMe.Print0(path)
-			return err
-		}
-		if commandLine.FlagPrintRecords() {
-			_, err := attr.FprintRecordsWithPath(os.Stdout, path)
-			return err
-		}
-		_, err := fmt.Printf("%s\n", path)
+// Prints path, or path and attr if records are to be printed.
+func printEntry(attr record.Attribute, path string) error {
+	if commandLine.FlagPrint0() {
+		_, err := printMe.Print0(path)
 		return err
 	}
+	if commandLine.FlagPrintRecords() {
+		//Goes through printMe, so records of concurrently printed files don't interleave
+		_, err := attr.FprintRecordsWithPath(printMe, path)
+		return err
+	}
+	_, err := printMe.Printf("%s\n", path)
+	return err
+}
+
+func printFile(parent string, info fs.FileInfo) error {
 	path := filepath.Join(parent, info.Name())
-	constraint := commandLine.PrintConstraint()
 	//Open file
 	f, err := os.Open(path)
 	if err != nil {
@@ -55,21 +56,29 @@ func printFile(parent string, info fs.FileInfo) error {
 		attr = attr.FilterByName(names...)
 	}
 
+	if printConstraintMatches(attr) {
+		return softerrors.Consume(printEntry(attr, path))
+	}
+	return nil
+}
+
+// Returns true if a file with Attribute attr is selected by the print constraint.
+func printConstraintMatches(attr record.Attribute) bool {
+	constraint := commandLine.PrintConstraint()
 	if len(attr) < 1 {
-		switch constraint {
-		case cli.PrintConstraintUntagged:
-			//Print recordless file if PrintConstraintUntagged is set
-			return softerrors.Consume(print(attr, path))
-		}
-		//Skip file otherwise
-		return nil
+		//Select recordless file only if PrintConstraintUntagged is set
+		return constraint == cli.PrintConstraintUntagged
 	}
 
 	switch constraint {
 	case cli.PrintConstraintNone:
-		return softerrors.Consume(print(attr, path)) //Print tagged file if no constraint is set
+		return true //Select tagged file if no constraint is set
 	case cli.PrintConstraintUntagged:
-		return nil //Skip tagged file
+		return false //Skip tagged file
+	case cli.PrintConstraintModified:
+		return hasInvalidReason(attr, record.ReasonModified)
+	case cli.PrintConstraintCorrupted:
+		return hasInvalidReason(attr, record.ReasonCorrupted)
 	}
 
 	//Iterate through Attributes to check for invalid and valid records
@@ -85,17 +94,22 @@ func printFile(parent string, info fs.FileInfo) error {
 
 	switch constraint {
 	case cli.PrintConstraintInvalid:
-		//Print if all records are invalid
-		if !hasValidEntry {
-			return softerrors.Consume(print(attr, path))
-		}
+		//Select if all records are invalid
+		return !hasValidEntry
 	case cli.PrintConstraintValid:
-		//Print if all records are valid
-		if !hasInvalidEntry {
-			return softerrors.Consume(print(attr, path))
-		}
+		//Select if all records are valid
+		return !hasInvalidEntry
 	default:
 		panic("You're not supposed to be here")
 	}
-	return nil
+}
+
+// Returns true if at least one record of attr was invalidated for reason.
+func hasInvalidReason(attr record.Attribute, reason record.InvalidReason) bool {
+	for _, rec := range attr {
+		if !rec.Valid && rec.Reason == reason {
+			return true
+		}
+	}
+	return false
 }
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"github.com/jwdev42/xtagger/internal/cli"
 	"github.com/jwdev42/xtagger/internal/logging"
+	"github.com/jwdev42/xtagger/internal/record"
 	"github.com/jwdev42/xtagger/internal/softerrors"
 	"github.com/jwdev42/xtagger/internal/xio/filesystem"
 	"github.com/jwdev42/xtagger/internal/xio/printer"
@@ -44,22 +45,66 @@ func Run() error {
 	dynamicLogLevel.Set(commandLine.FlagLogLevel())
 	//Setup printer
 	printMe = printer.NewPrinter(os.Stdout)
+	//Set attribute encoding and storage backend
+	record.SetEncoding(commandLine.FlagEncoding())
+	if backend := commandLine.FlagBackend(); backend != nil {
+		record.SetBackend(backend)
+	}
+	record.SetLockTimeout(commandLine.FlagLock())
 	//Set soft error behaviour
 	if commandLine.FlagQuitOnSoftError() {
 		softerrors.StopOnSoftError()
 	}
-	//Execute command-specific branch
+	//Open index
+	closeIndex, err := openIndex()
+	if err != nil {
+		return err
+	}
+	//Open hash cache
+	closeCache, err := openCache()
+	if err != nil {
+		return errors.Join(err, closeIndex())
+	}
+	//Execute command, then write pending changes of the index, the hash cache and the storage backend
+	err = runCommand()
+	return errors.Join(err, closeIndex(), closeCache(), record.CloseBackend())
+}
+
+// Executes the command-specific branch.
+func runCommand() error {
 	switch command := commandLine.Command(); command {
 	case cli.CommandTag:
 		return runWithOptionalMP(createContext(true), tagFile)
 	case cli.CommandPrint:
-		return run(createContext(false), printFile)
+		if commandLine.FlagOrdered() {
+			//Workers would print files in the order they finish
+			return run(createContext(false), printFile)
+		}
+		return runWithOptionalMP(createContext(false), printFile)
 	case cli.CommandUntag:
-		return run(createContext(true), untagFile)
+		return runWithOptionalMP(createContext(true), untagFile)
 	case cli.CommandInvalidate:
-		return run(createContext(true), invalidateFile)
+		return runValidate(createContext(true), false)
 	case cli.CommandRevalidate:
-		return run(createContext(true), revalidateFile)
+		return runValidate(createContext(true), true)
+	case cli.CommandVerify:
+		return run(createContext(true), verifyFile)
+	case cli.CommandCopy:
+		return runCopy(createContext(true))
+	case cli.CommandArchive:
+		return runArchive(createContext(true))
+	case cli.CommandMigrate:
+		return run(createContext(true), migrateFile)
+	case cli.CommandIndex:
+		return runIndex()
+	case cli.CommandExport:
+		return runExport(createContext(true))
+	case cli.CommandImport:
+		return runImport()
+	case cli.CommandHashes:
+		return listHashes()
+	case cli.CommandCache:
+		return runCache()
 	case cli.CommandLicenses:
 		printLicenses()
 	default:
@@ -80,8 +125,10 @@ func setupDefaultLogger() *slog.LevelVar {
 	return levelSwitch
 }
 
-// Runs fileFunc multithreaded if the corresponding flag was set.
+// Runs fileFunc multithreaded if the corresponding flag was set. fileFunc
+// must be safe for concurrent use.
 func runWithOptionalMP(opts *filesystem.Context, fileFunc filesystem.FileExaminer) error {
+	opts.Ordered = commandLine.FlagOrdered()
 	if commandLine.FlagMultiThread() {
 		return runMP(opts, fileFunc)
 	}
@@ -90,6 +137,14 @@ func runWithOptionalMP(opts *filesystem.Context, fileFunc filesystem.FileExamine
 
 // Main runner for fileFunc, singlethreaded by default, can be wrapped by runMP for multithreading.
 func run(opts *filesystem.Context, fileFunc filesystem.FileExaminer) error {
+	return runRoots(opts, func(string) filesystem.FileExaminer {
+		return fileFunc
+	})
+}
+
+// Runner for commands whose FileExaminer depends on the path argument it was
+// reached from. Function examinerFor is called once for every path argument.
+func runRoots(opts *filesystem.Context, examinerFor func(root string) filesystem.FileExaminer) error {
 	for _, path := range commandLine.Paths() {
 		info, err := os.Lstat(path)
 		if err != nil {
@@ -98,6 +153,7 @@ func run(opts *filesystem.Context, fileFunc filesystem.FileExaminer) error {
 			}
 			return err
 		}
+		fileFunc := examinerFor(path)
 		if info.IsDir() {
 			if commandLine.ForbidRecursion() {
 				if err := softerrors.Errorf("Recursion is forbidden, cannot descend in directory %s", path); err == nil {
@@ -121,21 +177,47 @@ func run(opts *filesystem.Context, fileFunc filesystem.FileExaminer) error {
 	return nil
 }
 
-// Wrapper for run that runs fileFunc in parallel.
+// Wrapper for run that runs fileFunc on a pool of workers. The directory
+// walker blocks while all workers are busy. The first error returned by
+// fileFunc stops the walk and is returned.
 func runMP(opts *filesystem.Context, fileFunc filesystem.FileExaminer) error {
+	type job struct {
+		parent string
+		info   fs.FileInfo
+	}
 	ctx, cancel := context.WithCancel(context.Background())
-	errs := make(chan error)
-	waitForErrorCollector := make(chan struct{})
-	waitForExaminers := new(sync.WaitGroup)
-	defer func() { <-waitForErrorCollector }()
-	defer close(errs)
-	defer waitForExaminers.Wait()
-	go func() {
-		defer close(waitForErrorCollector)
-		for err := <-errs; err != nil; err = <-errs {
-			slog.Error(err.Error())
+	defer cancel()
+	jobs := make(chan job)
+	var once sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	for range commandLine.FlagWorkers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if ctx.Err() != nil {
+					continue //Drain remaining jobs after an error
+				}
+				if err := fileFunc(j.parent, j.info); err != nil {
+					once.Do(func() { firstErr = err })
+					cancel()
+				}
+			}
+		}()
+	}
+	walkErr := run(opts, func(parent string, info fs.FileInfo) error {
+		select {
+		case jobs <- job{parent: parent, info: info}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		slog.Debug("runMP: Error callback goroutine exits...")
-	}()
-	return run(opts, wrapFileExaminer(ctx, cancel, waitForExaminers, errs, fileFunc))
+	})
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return walkErr
 }
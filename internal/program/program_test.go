@@ -0,0 +1,95 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"github.com/jwdev42/xtagger/internal/global"
+	"github.com/jwdev42/xtagger/internal/record"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Runs xtagger with the command line args and returns what it printed to
+// standard output. The test fails if Run returns an error.
+func runXtagger(t *testing.T, args ...string) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	stdout, osArgs := os.Stdout, os.Args
+	defer func() {
+		os.Stdout, os.Args = stdout, osArgs
+	}()
+	os.Stdout = w
+	os.Args = append([]string{"xtagger", "-ll", "error"}, args...)
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	global.ResetExitCode()
+	runErr := Run()
+	w.Close()
+	printed := <-output
+	r.Close()
+	if runErr != nil {
+		t.Fatalf("xtagger %v: %s", args, runErr)
+	}
+	return printed
+}
+
+// Creates files with the given contents in a new temporary directory.
+func createFiles(t *testing.T, contents map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range contents {
+		writeFile(t, filepath.Join(dir, name), content)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
+
+// Fails the test if the validity of the records of the file at path differs
+// from valid. Names missing in valid must not have a record.
+func expectRecords(t *testing.T, path string, valid map[string]bool) {
+	t.Helper()
+	attr, err := record.LoadAttribute(path)
+	if err != nil {
+		t.Fatalf("Could not load attribute of %s: %s", path, err)
+	}
+	if len(attr) != len(valid) {
+		t.Errorf("%s: Expected %d records, got %d", path, len(valid), len(attr))
+	}
+	for name, expected := range valid {
+		rec := attr[name]
+		if rec == nil {
+			t.Errorf("%s: Record %s is missing", path, name)
+		} else if rec.Valid != expected {
+			t.Errorf("%s: Expected validity of record %s to be %t", path, name, expected)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/cli"
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"github.com/jwdev42/xtagger/internal/record"
+	"github.com/jwdev42/xtagger/internal/softerrors"
+	"github.com/jwdev42/xtagger/internal/xio/filesystem"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Runs command export for checksum files. Paths are written relative to
+// the directory of the checksum file, so it can be checked with
+// "sha256sum -c" from there.
+func runExportChecksums(opts *filesystem.Context) error {
+	out, err := os.OpenFile(commandLine.Destination(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	outInfo, err := out.Stat()
+	if err != nil {
+		return err
+	}
+	outAbs, err := filepath.Abs(commandLine.Destination())
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	runErr := run(opts, func(parent string, info fs.FileInfo) error {
+		return exportChecksum(w, outInfo, filepath.Dir(outAbs), parent, info)
+	})
+	if err := w.Flush(); err != nil {
+		return errors.Join(runErr, err)
+	}
+	return errors.Join(runErr, out.Close())
+}
+
+func exportChecksum(w io.Writer, out fs.FileInfo, dir, parent string, info fs.FileInfo) error {
+	path := filepath.Join(parent, info.Name())
+	if os.SameFile(info, out) {
+		return nil //Skip the checksum file itself
+	}
+	//Load attribute
+	attr, err := record.LoadAttribute(path)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	name := commandLine.Names()[0]
+	rec := attr[name]
+	if rec == nil {
+		return nil
+	}
+	if !rec.Valid {
+		slog.Warn("Record is invalid, skipping", "path", path, "name", name)
+		return nil
+	}
+	if rec.ChunkSize > 0 {
+		slog.Warn("Checksums of chunked records can't be checked by other tools, skipping", "path", path, "name", name)
+		return nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return softerrors.Consume(err)
+	}
+	rel = filepath.ToSlash(rel)
+	var line string
+	if commandLine.FileFormat() == cli.FileFormatBSDChecksums {
+		line = hashes.FormatBSDSumLine(rec.HashAlgo, rec.Checksum, rel)
+	} else {
+		//GNU-style checksum files can't name the algorithm, so only one can be exported
+		if rec.HashAlgo != commandLine.FlagHash() {
+			slog.Warn("Hashing algorithm differs from the selected one, skipping", "path", path, "name", name, "algo", rec.HashAlgo)
+			return nil
+		}
+		line = hashes.FormatGNUSumLine(rec.Checksum, rel)
+	}
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+	//Print path if print0 is active
+	if commandLine.FlagPrint0() {
+		if _, err := printMe.Print0(path); err != nil {
+			return softerrors.Consume(err)
+		}
+	}
+	return nil
+}
+
+// Runs command import for checksum files. Relative paths in the checksum
+// file are resolved against ROOT.
+func runImportChecksums() error {
+	f, err := os.Open(commandLine.Source())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	root := commandLine.Destination()
+	name := commandLine.Names()[0]
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		algo, checksum, path, err := hashes.ParseSumLine(text, commandLine.FlagHash())
+		if err != nil {
+			return fmt.Errorf("%s, line %d: %s", commandLine.Source(), line, err)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, filepath.FromSlash(path))
+		}
+		rec := record.NewRecord()
+		rec.HashAlgo = algo
+		rec.Checksum = checksum
+		rec.Valid = true
+		imported := record.Attribute{name: rec}
+		if err := imported.Validate(); err != nil {
+			return fmt.Errorf("%s, line %d: %s", commandLine.Source(), line, err)
+		}
+		if err := importRecords(path, imported); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
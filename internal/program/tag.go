@@ -22,7 +22,6 @@ import (
 	"github.com/jwdev42/xtagger/internal/softerrors"
 	"io/fs"
 	"log/slog"
-	"os"
 	"path/filepath"
 )
 
@@ -30,9 +29,8 @@ func tagFile(parent string, info fs.FileInfo) error {
 	path := filepath.Join(parent, info.Name())
 	name := commandLine.Names()[0]
 	algo := commandLine.FlagHash()
-	constraint := commandLine.TagConstraint()
-	//Open file
-	f, err := os.Open(path)
+	//Open and lock file
+	f, err := record.OpenLocked(path)
 	if err != nil {
 		return softerrors.Consume(err)
 	}
@@ -42,41 +40,75 @@ func tagFile(parent string, info fs.FileInfo) error {
 	if err != nil {
 		return softerrors.Consume(err)
 	}
-	//Process untagged constraint
-	if constraint == cli.TagConstraintUntagged && len(attr) > 0 {
-		return nil //Skip already tagged files
-	}
-	//Process invalid constraint
-	if constraint == cli.TagConstraintInvalid {
-		for _, rec := range attr {
-			if rec.Valid {
-				//Skip files that have a valid record
-				return nil
-			}
-		}
+	//Process tag constraint
+	if skipByTagConstraint(attr) {
+		return nil
 	}
 	//Check if a record with the designated name already exists
 	if attr.Exists(name) {
 		return softerrors.Consume(fmt.Errorf("Record \"%s\" already exists for path \"%s\"", name, path))
 	}
-	//Hash file
-	slog.Debug("Hashing file", "path", path)
-	hash := algo.New()
-	if err := hashes.Hash(f, hash); err != nil {
+	//Stat file before hashing, so later modifications show up in the metadata
+	stat, err := f.Stat()
+	if err != nil {
 		return softerrors.Consume(err)
 	}
 	//Create record
 	slog.Debug("Create new tag record", "path", path)
 	rec := record.NewRecord()
-	rec.Checksum = fmt.Sprintf("%x", hash.Sum(nil))
 	rec.HashAlgo = algo
 	rec.Valid = true
+	rec.SetFileMeta(stat)
+	//Hash file
+	slog.Debug("Hashing file", "path", path)
+	if chunkSize := commandLine.FlagChunkSize(); chunkSize > 0 {
+		tree, err := hashes.TreeHash(f, stat.Size(), algo, chunkSize)
+		if err != nil {
+			return softerrors.Consume(err)
+		}
+		rec.Checksum = fmt.Sprintf("%x", tree.Root)
+		rec.ChunkSize = chunkSize
+		//Store the chunk digests, so verify can locate changes
+		digests, err := record.FLoadDigests(f)
+		if err != nil {
+			return softerrors.Consume(err)
+		}
+		digests[name] = &record.Digests{HashAlgo: algo, BlockSize: chunkSize, Sums: tree.Leaves}
+		if err := digests.FStore(f); err != nil {
+			return softerrors.Consume(err)
+		}
+	} else if blockSize := commandLine.FlagDigestBlockSize(); blockSize > 0 {
+		sum, blocks, err := hashes.HashBlocks(f, algo, blockSize)
+		if err != nil {
+			return softerrors.Consume(err)
+		}
+		rec.Checksum = fmt.Sprintf("%x", sum)
+		rec.BlockSize = blockSize
+		//Store the block digests, so verify can locate corrupted blocks
+		digests, err := record.FLoadDigests(f)
+		if err != nil {
+			return softerrors.Consume(err)
+		}
+		digests[name] = &record.Digests{HashAlgo: algo, BlockSize: blockSize, Sums: blocks}
+		if err := digests.FStore(f); err != nil {
+			return softerrors.Consume(err)
+		}
+	} else {
+		sums, err := hashWhole(f, algo)
+		if err != nil {
+			return softerrors.Consume(err)
+		}
+		rec.Checksum = fmt.Sprintf("%x", sums[algo])
+	}
 	//Add record to attribute
 	attr[name] = rec
 	//Save attribute
 	if err := attr.FStore(f); err != nil {
 		return softerrors.Consume(err)
 	}
+	if err := updateIndex(path, attr); err != nil {
+		return err
+	}
 	// Send info log
 	slog.Info("Tagged file", "path", path, "checksum", rec.Checksum, "algorithm", rec.HashAlgo)
 	//Print path if print0 is active
@@ -87,3 +119,21 @@ func tagFile(parent string, info fs.FileInfo) error {
 	}
 	return nil
 }
+
+// Returns true if a file with Attribute attr must not be tagged because of
+// the tag constraint set on the command line.
+func skipByTagConstraint(attr record.Attribute) bool {
+	switch commandLine.TagConstraint() {
+	case cli.TagConstraintUntagged:
+		//Skip already tagged files
+		return len(attr) > 0
+	case cli.TagConstraintInvalid:
+		for _, rec := range attr {
+			if rec.Valid {
+				//Skip files that have a valid record
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -15,46 +15,94 @@
 package program
 
 import (
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/cli"
 	"github.com/jwdev42/xtagger/internal/record"
 	"github.com/jwdev42/xtagger/internal/softerrors"
 	"io/fs"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 )
 
 func untagFile(parent string, info fs.FileInfo) error {
 	path := filepath.Join(parent, info.Name())
-	//Open file
-	f, err := os.Open(path)
+	//Open and lock file
+	f, err := record.OpenLocked(path)
 	if err != nil {
-		return err
+		return softerrors.Consume(err)
 	}
 	defer f.Close()
-	if commandLine.Names() != nil {
-		attr, err := record.FLoadAttribute(f)
-		if err != nil {
-			return err
-		}
-		initialLength := len(attr)
-		for _, name := range commandLine.Names() {
-			delete(attr, name)
-		}
-		if initialLength == len(attr) {
-			//Return if attr didn't change
-			return nil
+	attr, err := record.FLoadAttribute(f)
+	if err != nil {
+		if commandLine.UntagConstraint() != cli.UntagConstraintAll {
+			return softerrors.Consume(err)
 		}
-		if err := attr.FStore(f); err != nil {
+		//Untag all removes unreadable attributes as well
+		slog.Warn("Removing unreadable attribute", "path", path, "error", err)
+		if err := purgeAttr(f, path); err != nil {
 			return err
 		}
+		return printUntagged(path, nil)
+	}
+	//Remove the records selected by the constraint
+	removed := untagRecords(attr)
+	if len(removed) == 0 {
+		return nil
+	}
+	if len(attr) == 0 {
+		err = purgeAttr(f, path)
+	} else if err = attr.FStore(f); err != nil {
+		err = softerrors.Consume(err)
 	} else {
-		if err := record.PurgeAttr(f); err != nil {
-			return softerrors.Consume(err)
+		err = updateIndex(path, attr)
+	}
+	if err != nil {
+		return err
+	}
+	return printUntagged(path, removed)
+}
+
+// Removes the records selected by the untag constraint from attr. Returns
+// the names of the removed records in lexical order.
+func untagRecords(attr record.Attribute) []string {
+	selected := attr
+	if names := commandLine.Names(); names != nil {
+		selected = attr.FilterByName(names...)
+	}
+	removed := make([]string, 0, len(selected))
+	for _, name := range slices.Sorted(maps.Keys(selected)) {
+		if commandLine.UntagConstraint() == cli.UntagConstraintInvalid && selected[name].Valid {
+			continue
 		}
+		delete(attr, name)
+		removed = append(removed, name)
+	}
+	return removed
+}
+
+// Removes the attribute of f, which is at path.
+func purgeAttr(f *os.File, path string) error {
+	if err := record.PurgeAttr(f); err != nil {
+		return softerrors.Consume(err)
 	}
+	return updateIndex(path, nil)
+}
+
+// Prints the number of records removed from the file at path, followed by
+// their names. Only path is printed in print0 mode.
+func printUntagged(path string, removed []string) error {
 	if commandLine.FlagPrint0() {
-		if _, err := printMe.Print0(path); err != nil {
-			return err
-		}
+		_, err := printMe.Print0(path)
+		return softerrors.Consume(err)
+	}
+	lines := fmt.Sprintf("UNTAGGED %d %s\n", len(removed), path)
+	for _, name := range removed {
+		lines += fmt.Sprintf("\t%s\n", name)
 	}
-	return nil
+	//Written at once, so the lines of concurrently untagged files don't interleave
+	_, err := printMe.Write([]byte(lines))
+	return softerrors.Consume(err)
 }
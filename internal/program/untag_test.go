@@ -0,0 +1,96 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Creates the files a and b in a new directory. Record first of a and
+// record second of both files are invalid, record third of both files is
+// valid. Returns the directory.
+func createUntagFixture(t *testing.T) string {
+	dir := createFiles(t, map[string]string{"a": "content a", "b": "content b"})
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	runXtagger(t, "tag", "as", "first", "for", a)
+	runXtagger(t, "tag", "as", "second", "for", dir)
+	writeFile(t, a, "modified a")
+	writeFile(t, b, "modified b")
+	runXtagger(t, "invalidate", "all", "for", dir)
+	runXtagger(t, "tag", "as", "third", "for", dir)
+	expectRecords(t, a, map[string]bool{"first": false, "second": false, "third": true})
+	expectRecords(t, b, map[string]bool{"second": false, "third": true})
+	return dir
+}
+
+func TestUntag(t *testing.T) {
+	type test struct {
+		args   []string
+		output []string //Expected lines, files may be printed in any order
+		a, b   map[string]bool
+	}
+	tests := map[string]test{
+		"all": {
+			args:   []string{"all"},
+			output: []string{"UNTAGGED 3 A\n\tfirst\n\tsecond\n\tthird\n", "UNTAGGED 2 B\n\tsecond\n\tthird\n"},
+			a:      map[string]bool{},
+			b:      map[string]bool{},
+		},
+		"invalid": {
+			args:   []string{"invalid"},
+			output: []string{"UNTAGGED 2 A\n\tfirst\n\tsecond\n", "UNTAGGED 1 B\n\tsecond\n"},
+			a:      map[string]bool{"third": true},
+			b:      map[string]bool{"third": true},
+		},
+		"names": {
+			args:   []string{"name", "first", "and", "name", "third"},
+			output: []string{"UNTAGGED 2 A\n\tfirst\n\tthird\n", "UNTAGGED 1 B\n\tthird\n"},
+			a:      map[string]bool{"second": false},
+			b:      map[string]bool{"second": false},
+		},
+		"names if invalid": {
+			args:   []string{"name", "first", "and", "name", "third", "if", "invalid"},
+			output: []string{"UNTAGGED 1 A\n\tfirst\n"},
+			a:      map[string]bool{"second": false, "third": true},
+			b:      map[string]bool{"second": false, "third": true},
+		},
+		"unknown name": {
+			args: []string{"name", "fourth"},
+			a:    map[string]bool{"first": false, "second": false, "third": true},
+			b:    map[string]bool{"second": false, "third": true},
+		},
+	}
+	for desc, test := range tests {
+		dir := createUntagFixture(t)
+		a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+		args := append(append([]string{"-mt", "untag"}, test.args...), "for", dir)
+		output := runXtagger(t, args...)
+		expectedLength := 0
+		for _, line := range test.output {
+			line = strings.Replace(strings.Replace(line, " A\n", " "+a+"\n", 1), " B\n", " "+b+"\n", 1)
+			expectedLength += len(line)
+			if !strings.Contains(output, line) {
+				t.Errorf("%s: Output lacks %q: %q", desc, line, output)
+			}
+		}
+		if len(output) != expectedLength {
+			t.Errorf("%s: Unexpected output: %q", desc, output)
+		}
+		expectRecords(t, a, test.a)
+		expectRecords(t, b, test.b)
+	}
+}
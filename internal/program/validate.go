@@ -15,47 +15,81 @@
 package program
 
 import (
-	"fmt"
-	"github.com/jwdev42/xtagger/internal/hashes"
 	"github.com/jwdev42/xtagger/internal/record"
 	"github.com/jwdev42/xtagger/internal/softerrors"
-	"hash"
+	"github.com/jwdev42/xtagger/internal/xio/filesystem"
 	"io/fs"
-	"os"
+	"log/slog"
+	"maps"
 	"path/filepath"
+	"slices"
+	"sync"
 )
 
-func invalidateFile(parent string, info fs.FileInfo) error {
-	return reOrInvalidateFile(false, parent, info)
+// Counts the records flipped by invalidate or revalidate. Safe for
+// concurrent use.
+type flipReport struct {
+	mu      sync.Mutex
+	files   int
+	records int
+	byName  map[string]int
 }
 
-func revalidateFile(parent string, info fs.FileInfo) error {
-	return reOrInvalidateFile(true, parent, info)
+func newFlipReport() *flipReport {
+	return &flipReport{byName: make(map[string]int)}
 }
 
-func reOrInvalidateFile(revalidate bool, parent string, info fs.FileInfo) error {
-	path := filepath.Join(parent, info.Name())
-	names := commandLine.Names()
-	filteredRecords := func(attr record.Attribute) record.Attribute {
-		if names != nil {
-			return attr.FilterByName(names...)
-		}
-		return attr
+func (r *flipReport) add(names []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files++
+	r.records += len(names)
+	for _, name := range names {
+		r.byName[name]++
 	}
-	fillHashMap := func(attr record.Attribute) map[hashes.Algo]hash.Hash {
-		hashMap := make(map[hashes.Algo]hash.Hash)
-		for _, rec := range attr {
-			if !rec.Valid {
-				continue
-			}
-			if hashMap[rec.HashAlgo] == nil {
-				hashMap[rec.HashAlgo] = rec.HashAlgo.New()
-			}
+}
+
+// Prints the number of flipped records, then the number per name. Nothing
+// is printed in print0 mode.
+func (r *flipReport) print(verb string) error {
+	if commandLine.FlagPrint0() {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := printMe.Printf("%s %d records of %d files\n", verb, r.records, r.files); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(r.byName)) {
+		if _, err := printMe.Printf("\t%s: %d\n", name, r.byName[name]); err != nil {
+			return err
 		}
-		return hashMap
 	}
-	//Open file
-	f, err := os.Open(path)
+	return nil
+}
+
+// Runs command invalidate or revalidate.
+func runValidate(opts *filesystem.Context, revalidate bool) error {
+	report := newFlipReport()
+	err := runWithOptionalMP(opts, func(parent string, info fs.FileInfo) error {
+		return reOrInvalidateFile(report, revalidate, parent, info)
+	})
+	verb := "Invalidated"
+	if revalidate {
+		verb = "Revalidated"
+	}
+	if err := report.print(verb); err != nil {
+		return softerrors.Consume(err)
+	}
+	return err
+}
+
+// Invalidates the valid records of a file that don't match it anymore, or
+// revalidates the invalid records that match it again.
+func reOrInvalidateFile(report *flipReport, revalidate bool, parent string, info fs.FileInfo) error {
+	path := filepath.Join(parent, info.Name())
+	//Open and lock file
+	f, err := record.OpenLocked(path)
 	if err != nil {
 		return softerrors.Consume(err)
 	}
@@ -65,42 +99,90 @@ func reOrInvalidateFile(revalidate bool, parent string, info fs.FileInfo) error
 	if err != nil {
 		return softerrors.Consume(err)
 	}
-	return nil
-	//Fill hashMap for MultiHash
-	hashMap := fillHashMap(filteredRecords(attr))
-	//Generate hashes
-	if err := hashes.MultiHash(f, hashMap); err != nil {
-		return softerrors.Consume(err)
+	//Select records that can be flipped
+	candidates := make(record.Attribute)
+	selected := attr
+	if names := commandLine.Names(); names != nil {
+		selected = attr.FilterByName(names...)
 	}
-
-	var modified bool
-	for _, rec := range filteredRecords(attr) {
-		if revalidate {
-			//Revalidate outdated records
-			if fmt.Sprintf("%x", hashMap[rec.HashAlgo].Sum(nil)) == rec.Checksum {
-				rec.Valid = true
-				modified = true
-			}
-		} else {
-			//Invalidate outdated records
-			if fmt.Sprintf("%x", hashMap[rec.HashAlgo].Sum(nil)) != rec.Checksum {
+	for name, rec := range selected {
+		if rec.Valid != revalidate {
+			candidates[name] = rec
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	flipped := make([]string, 0, len(candidates))
+	if !revalidate && commandLine.FlagFast() {
+		//Fast invalidation compares file metadata instead of hashing
+		stat, err := f.Stat()
+		if err != nil {
+			return softerrors.Consume(err)
+		}
+		for name, rec := range candidates {
+			if rec.FileMetaChanged(stat) {
+				slog.Info("Invalidated record", "path", path, "name", name, "reason", record.ReasonModified)
 				rec.Valid = false
-				modified = true
+				rec.Reason = record.ReasonModified
+				flipped = append(flipped, name)
+			}
+		}
+	} else {
+		//Stat before hashing, the metadata tells modification and corruption apart
+		stat, err := f.Stat()
+		if err != nil {
+			return softerrors.Consume(err)
+		}
+		sums, err := checksums(f, candidates)
+		if err != nil {
+			return softerrors.Consume(err)
+		}
+		for name, rec := range candidates {
+			if sums.matches(rec) != revalidate {
+				continue
+			}
+			if revalidate {
+				slog.Info("Revalidated record", "path", path, "name", name)
+				rec.Reason = record.ReasonNone
+				//The content matches, so the file's current metadata belongs to the record
+				rec.SetFileMeta(stat)
+			} else {
+				rec.Reason = rec.MismatchReason(stat)
+				if rec.Reason == record.ReasonCorrupted {
+					slog.Warn("Checksum mismatch with unchanged file metadata, file may be corrupted", "path", path, "name", name)
+				} else {
+					slog.Info("Invalidated record", "path", path, "name", name, "reason", rec.Reason)
+				}
 			}
+			rec.Valid = revalidate
+			flipped = append(flipped, name)
 		}
 	}
-	if !modified {
+	if len(flipped) == 0 {
 		return nil
 	}
 	//Save attribute
 	if err := attr.FStore(f); err != nil {
 		return softerrors.Consume(err)
 	}
-	//Print path if print0 is active
+	if err := updateIndex(path, attr); err != nil {
+		return err
+	}
+	report.add(flipped)
+	//Print path in print0 mode, the number of flipped records otherwise
 	if commandLine.FlagPrint0() {
 		if _, err := printMe.Print0(path); err != nil {
 			return softerrors.Consume(err)
 		}
+	} else {
+		verb := "INVALIDATED"
+		if revalidate {
+			verb = "REVALIDATED"
+		}
+		if _, err := printMe.Printf("%s %d %s\n", verb, len(flipped), path); err != nil {
+			return softerrors.Consume(err)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,156 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"github.com/jwdev42/xtagger/internal/record"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvalidateRevalidate(t *testing.T) {
+	dir := createFiles(t, map[string]string{
+		"a":     "content a",
+		"b":     "content b",
+		"sub/c": "content c",
+	})
+	a, b, c := filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "sub", "c")
+	runXtagger(t, "tag", "as", "first", "for", dir)
+	runXtagger(t, "-chunk", "4", "tag", "as", "second", "for", dir)
+	runXtagger(t, "-hash", "blake3", "tag", "as", "third", "for", c)
+	//Nothing changed, nothing is invalidated
+	output := runXtagger(t, "invalidate", "all", "for", dir)
+	if output != "Invalidated 0 records of 0 files\n" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+	//Modify a and c
+	writeFile(t, a, "modified a")
+	writeFile(t, c, "modified c")
+	output = runXtagger(t, "-mt", "invalidate", "all", "for", dir)
+	for _, line := range []string{
+		"INVALIDATED 2 " + a + "\n",
+		"INVALIDATED 3 " + c + "\n",
+		"Invalidated 5 records of 2 files\n\tfirst: 2\n\tsecond: 2\n\tthird: 1\n",
+	} {
+		if !strings.Contains(output, line) {
+			t.Errorf("Output lacks %q: %q", line, output)
+		}
+	}
+	expectRecords(t, a, map[string]bool{"first": false, "second": false})
+	expectRecords(t, b, map[string]bool{"first": true, "second": true})
+	expectRecords(t, c, map[string]bool{"first": false, "second": false, "third": false})
+	//Invalid records are not invalidated again
+	if output := runXtagger(t, "invalidate", "all", "for", dir); output != "Invalidated 0 records of 0 files\n" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+	//Revalidating modified files does nothing
+	if output := runXtagger(t, "revalidate", "all", "for", dir); output != "Revalidated 0 records of 0 files\n" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+	//Restore the files, then revalidate selected names
+	writeFile(t, a, "content a")
+	writeFile(t, c, "content c")
+	output = runXtagger(t, "revalidate", "name", "second", "and", "name", "third", "for", dir)
+	for _, line := range []string{
+		"REVALIDATED 1 " + a + "\n",
+		"REVALIDATED 2 " + c + "\n",
+		"Revalidated 3 records of 2 files\n\tsecond: 2\n\tthird: 1\n",
+	} {
+		if !strings.Contains(output, line) {
+			t.Errorf("Output lacks %q: %q", line, output)
+		}
+	}
+	expectRecords(t, a, map[string]bool{"first": false, "second": true})
+	expectRecords(t, c, map[string]bool{"first": false, "second": true, "third": true})
+	//print0 mode only prints the paths of modified files
+	if output := runXtagger(t, "-print0", "revalidate", "all", "for", dir); output != a+"\x00" && output != c+"\x00"+a+"\x00" && output != a+"\x00"+c+"\x00" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+	expectRecords(t, a, map[string]bool{"first": true, "second": true})
+	expectRecords(t, c, map[string]bool{"first": true, "second": true, "third": true})
+}
+
+func TestFastInvalidate(t *testing.T) {
+	dir := createFiles(t, map[string]string{"a": "content a", "b": "content b"})
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	runXtagger(t, "tag", "as", "first", "for", dir)
+	//Same size, but a different modification time
+	writeFile(t, a, "content x")
+	if err := os.Chtimes(a, time.Time{}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	output := runXtagger(t, "-fast", "invalidate", "all", "for", dir)
+	if output != "INVALIDATED 1 "+a+"\nInvalidated 1 records of 1 files\n\tfirst: 1\n" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+	expectRecords(t, a, map[string]bool{"first": false})
+	expectRecords(t, b, map[string]bool{"first": true})
+}
+
+// Fails the test if the invalidation reason of record name of the file at
+// path differs from expected.
+func expectReason(t *testing.T, path, name string, expected record.InvalidReason) {
+	t.Helper()
+	attr, err := record.LoadAttribute(path)
+	if err != nil {
+		t.Fatalf("Could not load attribute of %s: %s", path, err)
+	}
+	if rec := attr[name]; rec == nil {
+		t.Errorf("%s: Record %s is missing", path, name)
+	} else if rec.Reason != expected {
+		t.Errorf("%s: Expected reason %q for record %s, got %q", path, expected, name, rec.Reason)
+	}
+}
+
+func TestInvalidationReason(t *testing.T) {
+	dir := createFiles(t, map[string]string{"a": "content a", "b": "content b", "c": "content c"})
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	runXtagger(t, "tag", "as", "first", "for", dir)
+	//Edit a
+	writeFile(t, a, "modified a")
+	//Corrupt b: same size, restored modification time
+	info, err := os.Stat(b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	writeFile(t, b, "content x")
+	if err := os.Chtimes(b, time.Time{}, info.ModTime()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	runXtagger(t, "invalidate", "all", "for", dir)
+	expectReason(t, a, "first", record.ReasonModified)
+	expectReason(t, b, "first", record.ReasonCorrupted)
+	//Filter print by reason
+	if output := runXtagger(t, "print", "modified", "for", dir); output != a+"\n" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+	if output := runXtagger(t, "print", "corrupted", "for", dir); output != b+"\n" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+	//Revalidation clears the reason
+	writeFile(t, b, "content b")
+	runXtagger(t, "revalidate", "all", "for", dir)
+	expectRecords(t, b, map[string]bool{"first": true})
+	expectReason(t, b, "first", record.ReasonNone)
+	if output := runXtagger(t, "print", "corrupted", "for", dir); output != "" {
+		t.Errorf("Unexpected output: %q", output)
+	}
+	//Revalidation stores the metadata of the restored file
+	runXtagger(t, "-fast", "invalidate", "all", "for", b)
+	expectRecords(t, b, map[string]bool{"first": true})
+}
@@ -0,0 +1,185 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/global"
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"github.com/jwdev42/xtagger/internal/record"
+	"github.com/jwdev42/xtagger/internal/softerrors"
+	"github.com/jwdev42/xtagger/internal/xio/filesystem"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Per-file results of command verify.
+const (
+	verifyOK           = "OK"           //All selected records match the file's content.
+	verifyMismatch     = "MISMATCH"     //At least one selected record does not match the file's content.
+	verifyUnverifiable = "UNVERIFIABLE" //The file has no selected records or could not be read.
+)
+
+// Compares the selected records of a file against its content.
+// Neither the file nor its extended attributes are modified.
+func verifyFile(parent string, info fs.FileInfo) error {
+	path := filepath.Join(parent, info.Name())
+	//Open file
+	f, err := filesystem.OpenReadOnly(path)
+	if err != nil {
+		return reportVerifyResult(verifyUnverifiable, path, err)
+	}
+	defer f.Close()
+	//Load attribute
+	attr, err := record.FLoadAttribute(f)
+	if err != nil {
+		return reportVerifyResult(verifyUnverifiable, path, err)
+	}
+	//Filter attribute by name
+	if names := commandLine.Names(); names != nil {
+		attr = attr.FilterByName(names...)
+	}
+	if len(attr) < 1 {
+		return reportVerifyResult(verifyUnverifiable, path, nil)
+	}
+	//Hash file once for all algorithms in use
+	slog.Debug("Hashing file", "path", path)
+	sums, err := checksums(f, attr)
+	if err != nil {
+		return reportVerifyResult(verifyUnverifiable, path, err)
+	}
+	//Compare records
+	result := verifyOK
+	changed := make(map[string][]byteRange)
+	for name, rec := range attr {
+		if sums.matches(rec) {
+			continue
+		}
+		slog.Warn("Checksum mismatch", "path", path, "name", name, "algorithm", rec.HashAlgo)
+		result = verifyMismatch
+		var ranges []byteRange
+		if rec.ChunkSize > 0 {
+			ranges, err = changedBlocks(f, name, rec, sums[specOf(rec)].Leaves)
+		} else if rec.BlockSize > 0 && commandLine.VerifyBlocks() {
+			ranges, err = corruptedBlocks(f, name, rec)
+		}
+		if err != nil {
+			slog.Warn("Could not locate changed blocks", "path", path, "name", name, "error", err)
+			continue
+		}
+		if len(ranges) > 0 {
+			changed[name] = ranges
+		}
+	}
+	if err := reportVerifyResult(result, path, nil); err != nil {
+		return err
+	}
+	return reportChangedRanges(changed)
+}
+
+// Range of bytes within a file, End is exclusive.
+type byteRange struct {
+	Start, End int64
+}
+
+// Returns the byte ranges of the blocks of File f whose digests in current
+// differ from the digests stored for record rec. Adjacent ranges are merged.
+// Returns no ranges if no digests are stored for rec.
+func changedBlocks(f *os.File, name string, rec *record.Record, current [][]byte) ([]byteRange, error) {
+	set, err := record.FLoadDigests(f)
+	if err != nil {
+		return nil, err
+	}
+	stored := set[name]
+	if stored == nil || stored.HashAlgo != rec.HashAlgo || stored.BlockSize != max(rec.ChunkSize, rec.BlockSize) {
+		return nil, nil
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	//Shrunk files are compared up to their size at the record's creation
+	size := max(stat.Size(), rec.Size)
+	var ranges []byteRange
+	for i := range max(len(stored.Sums), len(current)) {
+		if i < len(stored.Sums) && i < len(current) && bytes.Equal(stored.Sums[i], current[i]) {
+			continue
+		}
+		start, end := stored.Range(i, size)
+		if len(ranges) > 0 && ranges[len(ranges)-1].End == start {
+			ranges[len(ranges)-1].End = end
+		} else {
+			ranges = append(ranges, byteRange{Start: start, End: end})
+		}
+	}
+	return ranges, nil
+}
+
+// Rehashes File f block by block and returns the byte ranges whose digests
+// differ from the block digests stored for record rec.
+func corruptedBlocks(f *os.File, name string, rec *record.Record) ([]byteRange, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	_, blocks, err := hashes.HashBlocks(f, rec.HashAlgo, rec.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	return changedBlocks(f, name, rec, blocks)
+}
+
+// Prints the changed byte ranges of each record below the verification
+// result. Nothing is printed in print0 mode.
+func reportChangedRanges(changed map[string][]byteRange) error {
+	if commandLine.FlagPrint0() {
+		return nil
+	}
+	for _, name := range slices.Sorted(maps.Keys(changed)) {
+		for _, r := range changed[name] {
+			if _, err := printMe.Printf("\t%s: bytes %d-%d changed\n", name, r.Start, r.End-1); err != nil {
+				return softerrors.Consume(err)
+			}
+		}
+	}
+	return nil
+}
+
+// Prints the verification result for path. If err is non-nil, it is
+// handled as soft error after the result has been printed.
+func reportVerifyResult(result, path string, err error) error {
+	if result == verifyMismatch {
+		global.SetExitCode(global.ExitMismatch)
+	}
+	if commandLine.FlagPrint0() {
+		//Only mismatching files are printed in print0 mode
+		if result == verifyMismatch {
+			if _, err := printMe.Print0(path); err != nil {
+				return softerrors.Consume(err)
+			}
+		}
+	} else if _, err := printMe.Printf("%s %s\n", result, path); err != nil {
+		return softerrors.Consume(err)
+	}
+	if err != nil {
+		return softerrors.Consume(fmt.Errorf("Could not verify %s: %s", path, err))
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package program
+
+import (
+	"github.com/jwdev42/xtagger/internal/global"
+	"github.com/pkg/xattr"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyExitCode(t *testing.T) {
+	dir := createFiles(t, map[string]string{"a": "content a", "b": "content b"})
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	runXtagger(t, "tag", "as", "first", "for", dir)
+	writeFile(t, a, "modified")
+	//A malformed attribute makes b unverifiable and raises a soft error
+	if err := xattr.Set(b, "user.xtagger", []byte("{")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	//The mismatch must win in both orders
+	for _, paths := range [][]string{{a, b}, {b, a}} {
+		output := runXtagger(t, append([]string{"verify", "all", "for"}, paths...)...)
+		if !strings.Contains(output, "MISMATCH "+a+"\n") || !strings.Contains(output, "UNVERIFIABLE "+b+"\n") {
+			t.Errorf("Unexpected output: %q", output)
+		}
+		if code := global.ExitCode(); code != global.ExitMismatch {
+			t.Errorf("Paths %v: Expected exit code %d, got %d", paths, global.ExitMismatch, code)
+		}
+	}
+}
@@ -18,7 +18,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/pkg/xattr"
 	"io"
 	"os"
 )
@@ -26,7 +25,7 @@ import (
 // Represents the whole content of a user.xtagger xattr entry
 type Attribute map[string]*Record
 
-// Loads the xtagger extended attribute for path.
+// Loads the xtagger attribute for path.
 func LoadAttribute(path string) (Attribute, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -36,30 +35,39 @@ func LoadAttribute(path string) (Attribute, error) {
 	return FLoadAttribute(f)
 }
 
-// Loads the xtagger extended attribute for File f.
-// Returns an empty Attribute if the file does not have an extended attribute.
+// Loads the xtagger attribute for File f.
+// Returns an empty Attribute if the file does not have an attribute.
 func FLoadAttribute(f *os.File) (Attribute, error) {
-	//Read extended attribute
-	payload, err := xattr.FGet(f, attrName)
-	if errors.Is(err, xattr.ENOATTR) {
+	attr, _, err := FLoadAttributeWithVersion(f)
+	return attr, err
+}
+
+// Loads the xtagger attribute for File f and returns the schema
+// version it was stored with. Attributes of older versions are upgraded in
+// memory. Returns an empty Attribute and version 0 if the file does not have
+// an attribute.
+func FLoadAttributeWithVersion(f *os.File) (Attribute, int, error) {
+	//Read payload
+	payload, err := backend.ReadPayload(f)
+	if errors.Is(err, ErrNoAttribute) {
 		//Create a new Attribute if file doesn't have one yet
-		return make(Attribute), nil
+		return make(Attribute), 0, nil
 	} else if err != nil {
-		return nil, fmt.Errorf("Failed to read extended attribute: %s", err)
+		return nil, 0, fmt.Errorf("Failed to read attribute: %s", err)
 	}
-	//Decode JSON
-	attr := make(Attribute)
-	if err := json.Unmarshal([]byte(payload), &attr); err != nil {
-		return nil, fmt.Errorf("Failed to decode json: %s", err)
+	//Decode payload
+	attr, version, err := decodeAttribute(payload)
+	if err != nil {
+		return nil, 0, err
 	}
 	//Validation
 	if err := attr.validate(); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return attr, nil
+	return attr, version, nil
 }
 
-// Stores the xtagger extended attribute in path's inode.
+// Stores the xtagger attribute for path.
 func (r Attribute) Store(path string) error {
 	f, err := os.Open(path)
 	if err != nil {
@@ -69,7 +77,7 @@ func (r Attribute) Store(path string) error {
 	return r.FStore(f)
 }
 
-// Stores the xtagger extended attribute in File's inode.
+// Stores the xtagger attribute for File f.
 func (r Attribute) FStore(f *os.File) error {
 	if r == nil {
 		panic("BUG: Calling Store() with a nil receiver is prohibited")
@@ -78,16 +86,20 @@ func (r Attribute) FStore(f *os.File) error {
 	if err := r.validate(); err != nil {
 		return err
 	}
-	//Encode JSON
-	payload, err := json.Marshal(r)
+	//Encode payload
+	payload, err := encodeAttribute(r)
 	if err != nil {
-		return fmt.Errorf("Failed to encode json: %s", err)
+		return err
 	}
-	//Write extended attribute
-	if err := xattr.FSet(f, attrName, payload); err != nil {
-		return fmt.Errorf("Failed to write extended attribute: %s", err)
+	//Write payload
+	if err := backend.WritePayload(f, payload); err != nil {
+		if isSizeError(err) {
+			return fmt.Errorf("Attribute of %d bytes does not fit into the extended attributes of %s, try the binary encoding: %s", len(payload), f.Name(), err)
+		}
+		return fmt.Errorf("Failed to write attribute: %s", err)
 	}
-	return nil
+	//Drop digests of removed or replaced records
+	return pruneDigests(f, r)
 }
 
 // Returns the newest Record. Returns zero-values if no record was found.
@@ -121,6 +133,17 @@ func (r Attribute) FilterByName(name ...string) Attribute {
 	return attr
 }
 
+// Returns an Attribute holding all records with the given checksum.
+func (r Attribute) FilterByChecksum(checksum string) Attribute {
+	attr := make(Attribute)
+	for name, rec := range r {
+		if rec.Checksum == checksum {
+			attr[name] = rec
+		}
+	}
+	return attr
+}
+
 func (r Attribute) FprintRecordsWithPath(w io.Writer, path string) (n int, err error) {
 	container := struct {
 		Path    string
@@ -136,6 +159,11 @@ func (r Attribute) FprintRecordsWithPath(w io.Writer, path string) (n int, err e
 	return fmt.Fprintf(w, "%s\n", payload)
 }
 
+// Returns an error if the Attribute or one of its records is malformed.
+func (r Attribute) Validate() error {
+	return r.validate()
+}
+
 func (r Attribute) validate() error {
 	if r == nil {
 		return fmt.Errorf("Attribute %s cannot be null", attrName)
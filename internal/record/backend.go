@@ -0,0 +1,80 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Returned by Backend.ReadPayload if a file has no xtagger attribute.
+var ErrNoAttribute = errors.New("No xtagger attribute")
+
+// Backend stores the encoded payload of a file's Attribute.
+type Backend interface {
+	// Returns the payload stored for f. Returns an error wrapping ErrNoAttribute
+	// if f has no payload.
+	ReadPayload(f *os.File) ([]byte, error)
+	// Stores payload for f, replacing the previous payload.
+	WritePayload(f *os.File, payload []byte) error
+	// Returns the digest payload stored for f. Returns an error wrapping
+	// ErrNoAttribute if f has no digest payload.
+	ReadDigests(f *os.File) ([]byte, error)
+	// Stores the digest payload for f, an empty payload removes it.
+	WriteDigests(f *os.File, payload []byte) error
+	// Removes the payload and the digest payload of f. Does nothing if f has no payload.
+	RemovePayload(f *os.File) error
+	// Returns true if path is a file the backend uses for storage.
+	IsStorageFile(path string) bool
+	// Writes pending changes and releases the backend's resources.
+	Close() error
+}
+
+var backend Backend = new(XattrBackend)
+
+// Sets the backend for all subsequent attribute operations.
+func SetBackend(b Backend) {
+	backend = b
+}
+
+// Returns true if path is a file the current backend uses for storage.
+// Such files must not be tagged themselves.
+func IsStorageFile(path string) bool {
+	return backend.IsStorageFile(path)
+}
+
+// Closes the current backend.
+func CloseBackend() error {
+	return backend.Close()
+}
+
+// Returns the Backend described by spec, which is one of:
+//
+//	xattr          stores attributes as extended attributes (default)
+//	sidecar        stores attributes in a sidecar file per directory
+//	manifest:FILE  stores attributes of a whole tree in manifest FILE
+func NewBackend(spec string) (Backend, error) {
+	switch {
+	case spec == "xattr":
+		return new(XattrBackend), nil
+	case spec == "sidecar":
+		return new(SidecarBackend), nil
+	case strings.HasPrefix(spec, "manifest:"):
+		return NewManifestBackend(strings.TrimPrefix(spec, "manifest:"))
+	}
+	return nil, fmt.Errorf("Unknown storage backend %q", spec)
+}
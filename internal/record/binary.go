@@ -0,0 +1,221 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"io"
+)
+
+// Binary payloads start with a NUL byte, which can never start a JSON payload.
+var binaryMagic = []byte{0, 'X', 'T'}
+
+// Field tags of the binary record encoding. Every field is encoded as tag,
+// length and data. A record ends with binaryFieldEnd.
+const (
+	binaryFieldEnd       = iota
+	binaryFieldChecksum  //Raw checksum bytes
+	binaryFieldHashAlgo  //One byte algorithm ID
+	binaryFieldTimestamp //Varint
+	binaryFieldValid     //Empty, present if the record is valid
+	binaryFieldArchive   //String
+	binaryFieldSize      //Varint
+	binaryFieldMTime     //Varint
+	binaryFieldDevice    //Uvarint
+	binaryFieldInode     //Uvarint
+	binaryFieldChunkSize //Varint
+	binaryFieldBlockSize //Varint
+	binaryFieldReason    //String
+)
+
+func isBinaryPayload(payload []byte) bool {
+	return bytes.HasPrefix(payload, binaryMagic)
+}
+
+// Encodes attr in the compact binary format:
+//
+//	magic, version byte, uvarint record count, then for every record:
+//	uvarint name length, name, fields, binaryFieldEnd
+func encodeBinary(attr Attribute) ([]byte, error) {
+	buf := bytes.NewBuffer(bytes.Clone(binaryMagic))
+	buf.WriteByte(SchemaVersion)
+	buf.Write(binary.AppendUvarint(nil, uint64(len(attr))))
+	writeField := func(tag byte, data []byte) {
+		buf.WriteByte(tag)
+		buf.Write(binary.AppendUvarint(nil, uint64(len(data))))
+		buf.Write(data)
+	}
+	for name, rec := range attr {
+		buf.Write(binary.AppendUvarint(nil, uint64(len(name))))
+		buf.WriteString(name)
+		checksum, err := hex.DecodeString(rec.Checksum)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode checksum of record \"%s\": %s", name, err)
+		}
+		if err := rec.HashAlgo.Validate(); err != nil {
+			return nil, fmt.Errorf("Failed to encode record \"%s\": %s", name, err)
+		}
+		writeField(binaryFieldChecksum, checksum)
+		writeField(binaryFieldHashAlgo, []byte{rec.HashAlgo.ID()})
+		writeField(binaryFieldTimestamp, binary.AppendVarint(nil, rec.Timestamp))
+		if rec.Valid {
+			writeField(binaryFieldValid, nil)
+		}
+		if rec.Archive != "" {
+			writeField(binaryFieldArchive, []byte(rec.Archive))
+		}
+		if rec.HasFileMeta() {
+			writeField(binaryFieldSize, binary.AppendVarint(nil, rec.Size))
+			writeField(binaryFieldMTime, binary.AppendVarint(nil, rec.MTime))
+		}
+		if rec.Device != 0 || rec.Inode != 0 {
+			writeField(binaryFieldDevice, binary.AppendUvarint(nil, rec.Device))
+			writeField(binaryFieldInode, binary.AppendUvarint(nil, rec.Inode))
+		}
+		if rec.ChunkSize != 0 {
+			writeField(binaryFieldChunkSize, binary.AppendVarint(nil, rec.ChunkSize))
+		}
+		if rec.BlockSize != 0 {
+			writeField(binaryFieldBlockSize, binary.AppendVarint(nil, rec.BlockSize))
+		}
+		if rec.Reason != ReasonNone {
+			writeField(binaryFieldReason, []byte(rec.Reason))
+		}
+		buf.WriteByte(binaryFieldEnd)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decodes a payload created by encodeBinary, returns the Attribute and
+// the schema version of the payload.
+func decodeBinary(payload []byte) (Attribute, int, error) {
+	attr, version, err := decodeBinaryRecords(bytes.NewReader(payload[len(binaryMagic):]))
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed to decode binary attribute: %s", err)
+	}
+	return attr, version, nil
+}
+
+func decodeBinaryRecords(r *bytes.Reader) (Attribute, int, error) {
+	readBytes := func() ([]byte, error) {
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if length > uint64(r.Len()) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		data := make([]byte, length)
+		_, err = io.ReadFull(r, data)
+		return data, err
+	}
+	readVarint := func(data []byte) (int64, error) {
+		v, n := binary.Varint(data)
+		if n != len(data) {
+			return 0, errors.New("Malformed varint")
+		}
+		return v, nil
+	}
+	readUvarint := func(data []byte) (uint64, error) {
+		v, n := binary.Uvarint(data)
+		if n != len(data) {
+			return 0, errors.New("Malformed uvarint")
+		}
+		return v, nil
+	}
+	//Read header
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, 0, err
+	}
+	if version < 2 || version > SchemaVersion {
+		return nil, 0, fmt.Errorf("Unsupported schema version %d", version)
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	//Read records
+	attr := make(Attribute)
+	for i := uint64(0); i < count; i++ {
+		name, err := readBytes()
+		if err != nil {
+			return nil, 0, err
+		}
+		if attr.Exists(string(name)) {
+			return nil, 0, fmt.Errorf("Duplicate record \"%s\"", name)
+		}
+		rec := new(Record)
+		for {
+			tag, err := r.ReadByte()
+			if err != nil {
+				return nil, 0, err
+			}
+			if tag == binaryFieldEnd {
+				break
+			}
+			data, err := readBytes()
+			if err != nil {
+				return nil, 0, err
+			}
+			switch tag {
+			case binaryFieldChecksum:
+				rec.Checksum = hex.EncodeToString(data)
+			case binaryFieldHashAlgo:
+				if len(data) != 1 {
+					return nil, 0, errors.New("Malformed algorithm ID")
+				}
+				if rec.HashAlgo, err = hashes.AlgoByID(data[0]); err != nil {
+					return nil, 0, err
+				}
+			case binaryFieldTimestamp:
+				rec.Timestamp, err = readVarint(data)
+			case binaryFieldValid:
+				rec.Valid = true
+			case binaryFieldArchive:
+				rec.Archive = string(data)
+			case binaryFieldSize:
+				rec.Size, err = readVarint(data)
+			case binaryFieldMTime:
+				rec.MTime, err = readVarint(data)
+			case binaryFieldDevice:
+				rec.Device, err = readUvarint(data)
+			case binaryFieldInode:
+				rec.Inode, err = readUvarint(data)
+			case binaryFieldChunkSize:
+				rec.ChunkSize, err = readVarint(data)
+			case binaryFieldBlockSize:
+				rec.BlockSize, err = readVarint(data)
+			case binaryFieldReason:
+				rec.Reason = InvalidReason(data)
+			default:
+				return nil, 0, fmt.Errorf("Unknown field tag %d", tag)
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		attr[string(name)] = rec
+	}
+	if r.Len() > 0 {
+		return nil, 0, fmt.Errorf("%d bytes of trailing data", r.Len())
+	}
+	return attr, int(version), nil
+}
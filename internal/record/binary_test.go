@@ -0,0 +1,116 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"testing"
+)
+
+func TestBinaryEncoding(t *testing.T) {
+	samples := []Attribute{
+		{},
+		{
+			"私はウサギです": &Record{
+				Checksum:  "368b97b0b055910d97d284f834cbf1f8d5dec95b70576c8aedf6361e6a7bbc63",
+				HashAlgo:  hashes.SHA256,
+				Timestamp: 23,
+			},
+			"b": &Record{
+				Checksum:  "9c1185a5c5e9fc54612808977ee8f548b2258d31",
+				HashAlgo:  hashes.RIPEMD160,
+				Timestamp: -1686676137,
+				Valid:     true,
+				Archive:   "/dev/nst0",
+				Size:      0,
+				MTime:     1792311346066772937,
+				Device:    65024,
+				Inode:     9617610,
+			},
+			"c": &Record{
+				Checksum:  "9c1185a5c5e9fc54612808977ee8f548b2258d31",
+				HashAlgo:  hashes.RIPEMD160,
+				Timestamp: 1686676137,
+				Size:      512,
+				MTime:     1792311346066772937,
+				Reason:    ReasonCorrupted,
+			},
+		},
+	}
+	for i, sample := range samples {
+		payload, err := encodeBinary(sample)
+		if err != nil {
+			t.Errorf("Sample %d: Failed to encode: %s", i, err)
+			continue
+		}
+		jsonPayload, err := encodeAttribute(sample)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if len(payload) >= len(jsonPayload) {
+			t.Errorf("Sample %d: Binary payload (%d bytes) is not smaller than json payload (%d bytes)", i, len(payload), len(jsonPayload))
+		}
+		attr, version, err := decodeAttribute(payload)
+		if err != nil {
+			t.Errorf("Sample %d: Failed to decode: %s", i, err)
+			continue
+		}
+		if version != SchemaVersion {
+			t.Errorf("Sample %d: Expected version %d, got %d", i, SchemaVersion, version)
+		}
+		if !equalAttributes(sample, attr) {
+			t.Errorf("Sample %d: Decoded records differ from encoded records", i)
+		}
+		//Every truncation of a payload must be rejected
+		for j := len(binaryMagic); j < len(payload); j++ {
+			if _, _, err := decodeAttribute(payload[:j]); err == nil {
+				t.Errorf("Sample %d: Expected error for payload truncated to %d bytes", i, j)
+			}
+		}
+	}
+}
+
+func TestNegativeDecodeBinary(t *testing.T) {
+	samples := [][]byte{
+		{0, 'X', 'T'},
+		{0, 'X', 'T', 1, 0},                         //Version 1 has no binary encoding
+		{0, 'X', 'T', SchemaVersion + 1, 0},         //Newer than this program
+		{0, 'X', 'T', SchemaVersion, 0, 0},          //Trailing data
+		{0, 'X', 'T', SchemaVersion, 1, 1, 'a', 42}, //Unknown field tag
+		{0, 'X', 'T', SchemaVersion, 1, 1, 'a', binaryFieldHashAlgo, 1, 99, binaryFieldEnd}, //Unknown algorithm ID
+		{0, 'X', 'T', SchemaVersion, 2, 1, 'a', binaryFieldEnd, 1, 'a', binaryFieldEnd},     //Duplicate name
+	}
+	for i, sample := range samples {
+		if _, _, err := decodeAttribute(sample); err == nil {
+			t.Errorf("Index %d: Expected error for sample %v", i, sample)
+		}
+	}
+}
+
+func TestBinaryStoreAndLoad(t *testing.T) {
+	SetEncoding(EncodingBinary)
+	defer SetEncoding(EncodingJSON)
+	sample := Attribute{
+		"TestBackup123": &Record{
+			Checksum:  "1f2946e2fd7d0be6c4295c1ed828f0ff4aec21e89df898f9efbaddbe445c5c7c",
+			HashAlgo:  hashes.SHA256,
+			Timestamp: 1686676137,
+			Valid:     true,
+		},
+	}
+	if err := testAttributeStoreAndLoad(t, sample); err != nil {
+		t.Error(err)
+	}
+}
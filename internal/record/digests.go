@@ -0,0 +1,238 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"io"
+	"os"
+)
+
+// Digest payloads start with a NUL byte like binary attribute payloads.
+var digestsMagic = []byte{0, 'X', 'D'}
+
+const digestsVersion = 1
+
+// Digests of the consecutive blocks of a file, the last block may be shorter.
+// They are stored apart from the attribute as they grow with the file size.
+type Digests struct {
+	HashAlgo  hashes.Algo //Algorithm used for every block
+	BlockSize int64       //Size of the blocks in bytes
+	Sums      [][]byte    //Digest of each block
+}
+
+// Digests of a file's records, keyed by record name.
+type DigestSet map[string]*Digests
+
+// Returns the byte range of block i as offset of its first byte and offset
+// after its last byte, given a file of size bytes.
+func (r *Digests) Range(i int, size int64) (start, end int64) {
+	start = int64(i) * r.BlockSize
+	return start, min(start+r.BlockSize, size)
+}
+
+// Returns true if the digests belong to rec. The root of the digests of a
+// chunked record must match its checksum.
+func (r *Digests) belongsTo(rec *Record) bool {
+	if rec == nil || rec.HashAlgo != r.HashAlgo {
+		return false
+	}
+	switch {
+	case rec.ChunkSize > 0:
+		return rec.ChunkSize == r.BlockSize && hex.EncodeToString(hashes.TreeRoot(r.HashAlgo, r.BlockSize, r.Sums)) == rec.Checksum
+	case rec.BlockSize > 0:
+		return rec.BlockSize == r.BlockSize
+	}
+	return false
+}
+
+func (r *Digests) validate() error {
+	if r == nil {
+		return errors.New("Digests cannot be null")
+	}
+	if err := r.HashAlgo.Validate(); err != nil {
+		return err
+	}
+	if r.BlockSize < 1 {
+		return fmt.Errorf("Block size must be positive: %d", r.BlockSize)
+	}
+	for i, sum := range r.Sums {
+		if len(sum) != r.HashAlgo.Size() {
+			return fmt.Errorf("Digest of block %d has %d bytes, expected %d for %s", i, len(sum), r.HashAlgo.Size(), r.HashAlgo)
+		}
+	}
+	return nil
+}
+
+// Loads the digests of File f. Returns an empty DigestSet if f has none.
+func FLoadDigests(f *os.File) (DigestSet, error) {
+	payload, err := backend.ReadDigests(f)
+	if errors.Is(err, ErrNoAttribute) {
+		return make(DigestSet), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read digests: %s", err)
+	}
+	return decodeDigests(payload)
+}
+
+// Stores the digests of File f, removes them if the receiver is empty.
+func (r DigestSet) FStore(f *os.File) error {
+	var payload []byte
+	if len(r) > 0 {
+		var err error
+		if payload, err = encodeDigests(r); err != nil {
+			return err
+		}
+	}
+	if err := backend.WriteDigests(f, payload); err != nil {
+		if isSizeError(err) {
+			return fmt.Errorf("Digests of %d bytes do not fit into the extended attributes of %s, use a larger block size or the sidecar backend: %s", len(payload), f.Name(), err)
+		}
+		return fmt.Errorf("Failed to write digests: %s", err)
+	}
+	return nil
+}
+
+// Removes the digests of File f that don't belong to a record of attr anymore.
+func pruneDigests(f *os.File, attr Attribute) error {
+	set, err := FLoadDigests(f)
+	if err != nil || len(set) == 0 {
+		return err
+	}
+	var modified bool
+	for name, digests := range set {
+		if !digests.belongsTo(attr[name]) {
+			delete(set, name)
+			modified = true
+		}
+	}
+	if !modified {
+		return nil
+	}
+	return set.FStore(f)
+}
+
+// Encodes set as:
+//
+//	magic, version byte, uvarint entry count, then for every entry:
+//	uvarint name length, name, algorithm ID, uvarint block size,
+//	uvarint digest count, digests
+func encodeDigests(set DigestSet) ([]byte, error) {
+	buf := bytes.NewBuffer(bytes.Clone(digestsMagic))
+	buf.WriteByte(digestsVersion)
+	buf.Write(binary.AppendUvarint(nil, uint64(len(set))))
+	for name, digests := range set {
+		if err := digests.validate(); err != nil {
+			return nil, fmt.Errorf("Digests of record \"%s\": %s", name, err)
+		}
+		buf.Write(binary.AppendUvarint(nil, uint64(len(name))))
+		buf.WriteString(name)
+		buf.WriteByte(digests.HashAlgo.ID())
+		buf.Write(binary.AppendUvarint(nil, uint64(digests.BlockSize)))
+		buf.Write(binary.AppendUvarint(nil, uint64(len(digests.Sums))))
+		for _, sum := range digests.Sums {
+			buf.Write(sum)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeDigests(payload []byte) (DigestSet, error) {
+	set, err := decodeDigestEntries(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode digests: %s", err)
+	}
+	return set, nil
+}
+
+func decodeDigestEntries(payload []byte) (DigestSet, error) {
+	if !bytes.HasPrefix(payload, digestsMagic) {
+		return nil, errors.New("Unknown format")
+	}
+	r := bytes.NewReader(payload[len(digestsMagic):])
+	readUvarint := func(limit uint64) (uint64, error) {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return 0, err
+		}
+		if v > limit {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return v, nil
+	}
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version != digestsVersion {
+		return nil, fmt.Errorf("Unsupported version %d", version)
+	}
+	count, err := readUvarint(uint64(r.Len()))
+	if err != nil {
+		return nil, err
+	}
+	set := make(DigestSet)
+	for i := uint64(0); i < count; i++ {
+		length, err := readUvarint(uint64(r.Len()))
+		if err != nil {
+			return nil, err
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		if set[string(name)] != nil {
+			return nil, fmt.Errorf("Duplicate entry \"%s\"", name)
+		}
+		id, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		digests := new(Digests)
+		if digests.HashAlgo, err = hashes.AlgoByID(id); err != nil {
+			return nil, err
+		}
+		blockSize, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if blockSize < 1 || blockSize > 1<<62 {
+			return nil, fmt.Errorf("Invalid block size %d", blockSize)
+		}
+		digests.BlockSize = int64(blockSize)
+		size := uint64(digests.HashAlgo.Size())
+		sums, err := readUvarint(uint64(r.Len()) / size)
+		if err != nil {
+			return nil, err
+		}
+		digests.Sums = make([][]byte, sums)
+		for j := range digests.Sums {
+			digests.Sums[j] = make([]byte, size)
+			if _, err := io.ReadFull(r, digests.Sums[j]); err != nil {
+				return nil, err
+			}
+		}
+		set[string(name)] = digests
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("%d bytes of trailing data", r.Len())
+	}
+	return set, nil
+}
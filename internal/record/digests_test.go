@@ -0,0 +1,194 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"github.com/pkg/xattr"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func testDigests(t *testing.T, b Backend, dir string) {
+	SetBackend(b)
+	defer SetBackend(new(XattrBackend))
+	defer b.Close()
+	path := filepath.Join(dir, "file")
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer f.Close()
+	tree, err := hashes.TreeHash(f, int64(len(content)), hashes.SHA256, 4096)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	attr := Attribute{
+		"chunked": &Record{
+			Checksum:  fmt.Sprintf("%x", tree.Root),
+			HashAlgo:  hashes.SHA256,
+			Timestamp: 1686676137,
+			Valid:     true,
+			ChunkSize: tree.ChunkSize,
+		},
+		"other": &Record{
+			Checksum:  "1f2946e2fd7d0be6c4295c1ed828f0ff4aec21e89df898f9efbaddbe445c5c7c",
+			HashAlgo:  hashes.SHA256,
+			Timestamp: 1686676137,
+		},
+	}
+	set := DigestSet{"chunked": &Digests{HashAlgo: hashes.SHA256, BlockSize: tree.ChunkSize, Sums: tree.Leaves}}
+	if err := set.FStore(f); err != nil {
+		t.Fatalf("Failed to store digests: %s", err)
+	}
+	if err := attr.FStore(f); err != nil {
+		t.Fatalf("Failed to store attribute: %s", err)
+	}
+	loaded, err := FLoadDigests(f)
+	if err != nil {
+		t.Fatalf("Failed to load digests: %s", err)
+	}
+	if d := loaded["chunked"]; len(loaded) != 1 || d == nil || d.BlockSize != 4096 || !slices.EqualFunc(d.Sums, tree.Leaves, bytes.Equal) {
+		t.Errorf("Loaded digests don't match the stored ones")
+	}
+	//Removing the record must remove its digests
+	delete(attr, "chunked")
+	if err := attr.FStore(f); err != nil {
+		t.Fatalf("Failed to store attribute: %s", err)
+	}
+	if loaded, err := FLoadDigests(f); err != nil || len(loaded) != 0 {
+		t.Errorf("Expected digests to be pruned, got %d entries: %v", len(loaded), err)
+	}
+	//Digests of records with block digests are kept
+	attr["blocks"] = &Record{
+		Checksum:  "1f2946e2fd7d0be6c4295c1ed828f0ff4aec21e89df898f9efbaddbe445c5c7c",
+		HashAlgo:  hashes.SHA256,
+		Timestamp: 1686676137,
+		BlockSize: 4096,
+	}
+	blockSet := DigestSet{"blocks": &Digests{HashAlgo: hashes.SHA256, BlockSize: 4096, Sums: tree.Leaves}}
+	if err := blockSet.FStore(f); err != nil {
+		t.Fatalf("Failed to store digests: %s", err)
+	}
+	if err := attr.FStore(f); err != nil {
+		t.Fatalf("Failed to store attribute: %s", err)
+	}
+	if loaded, err := FLoadDigests(f); err != nil || loaded["blocks"] == nil {
+		t.Errorf("Expected block digests to be kept: %v", err)
+	}
+	//Purging must remove the digests
+	if err := set.FStore(f); err != nil {
+		t.Fatalf("Failed to store digests: %s", err)
+	}
+	if err := PurgeAttr(f); err != nil {
+		t.Fatalf("Failed to purge attribute: %s", err)
+	}
+	if loaded, err := FLoadDigests(f); err != nil || len(loaded) != 0 {
+		t.Errorf("Expected digests to be purged, got %d entries: %v", len(loaded), err)
+	}
+}
+
+func TestDigestsXattr(t *testing.T) {
+	dir, err := os.MkdirTemp(".", "testDigests")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	testDigests(t, new(XattrBackend), dir)
+}
+
+func TestDigestsSidecar(t *testing.T) {
+	testDigests(t, new(SidecarBackend), t.TempDir())
+}
+
+func TestDigestsManifest(t *testing.T) {
+	dir := t.TempDir()
+	b, err := NewManifestBackend(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	testDigests(t, b, dir)
+}
+
+func TestDigestsWriteFailure(t *testing.T) {
+	dir, err := os.MkdirTemp(".", "testDigests")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(size, max int) {
+		shardSize = size
+		maxValueSize = max
+	}(shardSize, maxValueSize)
+	shardSize = 64
+	maxValueSize = 128
+	path := filepath.Join(dir, "file")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer f.Close()
+	b := new(XattrBackend)
+	old := bytes.Repeat([]byte("old"), 200)
+	if err := b.WriteDigests(f, old); err != nil {
+		t.Fatalf("Failed to store digests: %s", err)
+	}
+	//A payload exceeding the space for extended attributes fails while its shards are written
+	if err := b.WriteDigests(f, bytes.Repeat([]byte("new"), 1<<20)); err == nil {
+		t.Skip("Filesystem stored the oversized payload")
+	}
+	if loaded, err := b.ReadDigests(f); err != nil || !bytes.Equal(loaded, old) {
+		t.Errorf("Previous digests were not kept after a failed write: %v", err)
+	}
+	set := currentShardSet(f, digestsAttrName)
+	names, err := xattr.FList(f)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, name := range names {
+		if s, _, ok := shardIndex(digestsAttrName, name); ok && s != set {
+			t.Errorf("Shard %s of the failed write was left behind", name)
+		}
+	}
+}
+
+func TestNegativeDecodeDigests(t *testing.T) {
+	valid, err := encodeDigests(DigestSet{"a": &Digests{HashAlgo: hashes.XXH64, BlockSize: 1, Sums: [][]byte{make([]byte, 8)}}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err := decodeDigests(valid); err != nil {
+		t.Fatalf("Failed to decode valid payload: %s", err)
+	}
+	for i := range len(valid) {
+		if _, err := decodeDigests(valid[:i]); err == nil {
+			t.Errorf("Expected an error for payload truncated to %d bytes", i)
+		}
+	}
+	if _, err := decodeDigests(append(valid, 0)); err == nil {
+		t.Error("Expected an error for trailing data")
+	}
+}
@@ -0,0 +1,57 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"fmt"
+)
+
+const (
+	EncodingJSON   Encoding = iota //Human-readable JSON, the default.
+	EncodingBinary                 //Compact binary encoding for small extended attribute limits.
+)
+
+// Represents the encoding used when storing an Attribute. On load, the
+// encoding is detected automatically.
+type Encoding int
+
+var storeEncoding = EncodingJSON
+
+// Sets the encoding for all subsequent calls to Store and FStore.
+func SetEncoding(encoding Encoding) {
+	storeEncoding = encoding
+}
+
+// Returns the Encoding corresponding to name, returns an error if name
+// does not represent an existing Encoding.
+func ParseEncoding(name string) (Encoding, error) {
+	switch name {
+	case "json", "JSON":
+		return EncodingJSON, nil
+	case "binary", "BINARY":
+		return EncodingBinary, nil
+	}
+	return EncodingJSON, fmt.Errorf("Unknown encoding %q", name)
+}
+
+func (r Encoding) String() string {
+	switch r {
+	case EncodingJSON:
+		return "json"
+	case EncodingBinary:
+		return "binary"
+	}
+	return fmt.Sprintf("Encoding(%d)", int(r))
+}
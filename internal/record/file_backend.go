@@ -0,0 +1,391 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Name of the per-directory file used by SidecarBackend.
+const SidecarName = ".xtagger.json"
+
+// Content of a sidecar or manifest file. Files maps file paths to their
+// payloads. JSON payloads are embedded as they are, binary payloads are
+// embedded as base64 strings. Digests maps file paths to their digest
+// payloads.
+type storageFile struct {
+	Version int                        `json:"v"`
+	Files   map[string]json.RawMessage `json:"files"`
+	Digests map[string][]byte          `json:"digests,omitempty"`
+}
+
+const storageFileVersion = 1
+
+// Storage files are replaced by renaming temporary files of this pattern.
+const storageTempPattern = ".xtagger-*.tmp"
+
+// Returns true if path is a temporary file created while saving a storage file.
+func isStorageTempFile(path string) bool {
+	matched, _ := filepath.Match(storageTempPattern, filepath.Base(path))
+	return matched
+}
+
+// Loads the storage file at path. Returns an empty storage file if path does not exist.
+func loadStorageFile(path string) (*storageFile, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &storageFile{Version: storageFileVersion, Files: make(map[string]json.RawMessage)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sf := new(storageFile)
+	if err := json.Unmarshal(content, sf); err != nil {
+		return nil, fmt.Errorf("Failed to decode %s: %s", path, err)
+	}
+	if sf.Version != storageFileVersion {
+		return nil, fmt.Errorf("Unsupported version %d of %s", sf.Version, path)
+	}
+	if sf.Files == nil {
+		sf.Files = make(map[string]json.RawMessage)
+	}
+	return sf, nil
+}
+
+// Atomically replaces the storage file at path. Removes it if it holds no files.
+func (r *storageFile) save(path string) error {
+	if len(r.Files) == 0 && len(r.Digests) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	content, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), storageTempPattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (r *storageFile) read(key string) ([]byte, error) {
+	entry, ok := r.Files[key]
+	if !ok {
+		return nil, ErrNoAttribute
+	}
+	if bytes.HasPrefix(entry, []byte(`"`)) {
+		//Binary payload
+		var payload []byte
+		if err := json.Unmarshal(entry, &payload); err != nil {
+			return nil, err
+		}
+		return payload, nil
+	}
+	return entry, nil
+}
+
+func (r *storageFile) readDigests(key string) ([]byte, error) {
+	payload, ok := r.Digests[key]
+	if !ok {
+		return nil, ErrNoAttribute
+	}
+	return payload, nil
+}
+
+// Stores the digest payload for key, an empty payload removes it.
+func (r *storageFile) writeDigests(key string, payload []byte) {
+	if len(payload) == 0 {
+		delete(r.Digests, key)
+		return
+	}
+	if r.Digests == nil {
+		r.Digests = make(map[string][]byte)
+	}
+	r.Digests[key] = bytes.Clone(payload)
+}
+
+// Removes the payload and the digest payload of key, returns false if
+// there was nothing to remove.
+func (r *storageFile) remove(key string) bool {
+	_, hasPayload := r.Files[key]
+	_, hasDigests := r.Digests[key]
+	delete(r.Files, key)
+	delete(r.Digests, key)
+	return hasPayload || hasDigests
+}
+
+func (r *storageFile) write(key string, payload []byte) error {
+	if json.Valid(payload) {
+		r.Files[key] = json.RawMessage(bytes.Clone(payload))
+		return nil
+	}
+	entry, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	r.Files[key] = entry
+	return nil
+}
+
+// SidecarBackend stores payloads in a file named SidecarName within the
+// directory of the tagged file. It works on filesystems without support
+// for extended attributes. If locking is enabled, the directory is locked
+// while its sidecar file is rewritten.
+type SidecarBackend struct {
+	mu sync.Mutex
+}
+
+// Returns the path of the sidecar file responsible for f and the key of f within it.
+func (r *SidecarBackend) location(f *os.File) (sidecar, key string) {
+	return filepath.Join(filepath.Dir(f.Name()), SidecarName), filepath.Base(f.Name())
+}
+
+func (r *SidecarBackend) ReadPayload(f *os.File) ([]byte, error) {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	path, key := r.location(f)
+	sf, err := loadStorageFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return sf.read(key)
+}
+
+func (r *SidecarBackend) WritePayload(f *os.File, payload []byte) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	path, key := r.location(f)
+	unlock, err := lockDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	sf, err := loadStorageFile(path)
+	if err != nil {
+		return err
+	}
+	if err := sf.write(key, payload); err != nil {
+		return err
+	}
+	return sf.save(path)
+}
+
+func (r *SidecarBackend) ReadDigests(f *os.File) ([]byte, error) {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	path, key := r.location(f)
+	sf, err := loadStorageFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return sf.readDigests(key)
+}
+
+func (r *SidecarBackend) WriteDigests(f *os.File, payload []byte) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	path, key := r.location(f)
+	unlock, err := lockDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	sf, err := loadStorageFile(path)
+	if err != nil {
+		return err
+	}
+	sf.writeDigests(key, payload)
+	return sf.save(path)
+}
+
+func (r *SidecarBackend) RemovePayload(f *os.File) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	path, key := r.location(f)
+	unlock, err := lockDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	sf, err := loadStorageFile(path)
+	if err != nil {
+		return err
+	}
+	if !sf.remove(key) {
+		return nil
+	}
+	return sf.save(path)
+}
+
+func (r *SidecarBackend) IsStorageFile(path string) bool {
+	return filepath.Base(path) == SidecarName || isStorageTempFile(path)
+}
+
+func (r *SidecarBackend) Close() error {
+	return nil
+}
+
+// ManifestBackend stores the payloads of a whole tree in a single manifest
+// file, keyed by their path relative to the manifest's directory. The
+// manifest is held in memory and written when the backend is closed.
+type ManifestBackend struct {
+	mu       sync.Mutex
+	path     string       //Absolute path of the manifest
+	root     string       //Directory of the manifest, paths are relative to it
+	manifest *storageFile //Loaded on first use
+	dirty    bool         //True if manifest has unsaved changes
+}
+
+// Returns a ManifestBackend for the manifest file at path. The file is
+// created on Close if it does not exist yet.
+func NewManifestBackend(path string) (*ManifestBackend, error) {
+	if path == "" {
+		return nil, errors.New("Manifest path cannot be empty")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ManifestBackend{
+		path: abs,
+		root: filepath.Dir(abs),
+	}, nil
+}
+
+// Returns the key of f within the manifest. Must be called with r.mu held.
+func (r *ManifestBackend) key(f *os.File) (string, error) {
+	if r.manifest == nil {
+		manifest, err := loadStorageFile(r.path)
+		if err != nil {
+			return "", err
+		}
+		r.manifest = manifest
+	}
+	abs, err := filepath.Abs(f.Name())
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(r.root, abs)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the tree of manifest %s", f.Name(), r.path)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func (r *ManifestBackend) ReadPayload(f *os.File) ([]byte, error) {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	key, err := r.key(f)
+	if err != nil {
+		return nil, err
+	}
+	return r.manifest.read(key)
+}
+
+func (r *ManifestBackend) WritePayload(f *os.File, payload []byte) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	key, err := r.key(f)
+	if err != nil {
+		return err
+	}
+	if err := r.manifest.write(key, payload); err != nil {
+		return err
+	}
+	r.dirty = true
+	return nil
+}
+
+func (r *ManifestBackend) ReadDigests(f *os.File) ([]byte, error) {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	key, err := r.key(f)
+	if err != nil {
+		return nil, err
+	}
+	return r.manifest.readDigests(key)
+}
+
+func (r *ManifestBackend) WriteDigests(f *os.File, payload []byte) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	key, err := r.key(f)
+	if err != nil {
+		return err
+	}
+	r.manifest.writeDigests(key, payload)
+	r.dirty = true
+	return nil
+}
+
+func (r *ManifestBackend) RemovePayload(f *os.File) error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	key, err := r.key(f)
+	if err != nil {
+		return err
+	}
+	if r.manifest.remove(key) {
+		r.dirty = true
+	}
+	return nil
+}
+
+func (r *ManifestBackend) IsStorageFile(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	return abs == r.path || isStorageTempFile(path) && filepath.Dir(abs) == r.root
+}
+
+// Writes the manifest if it was modified.
+func (r *ManifestBackend) Close() error {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	if !r.dirty {
+		return nil
+	}
+	if err := r.manifest.save(r.path); err != nil {
+		return fmt.Errorf("Failed to write manifest %s: %s", r.path, err)
+	}
+	r.dirty = false
+	return nil
+}
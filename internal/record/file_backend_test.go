@@ -0,0 +1,115 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"github.com/pkg/xattr"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testFileBackend(t *testing.T, b Backend, dir string, storage string) {
+	SetBackend(b)
+	defer SetBackend(new(XattrBackend))
+	sample := Attribute{
+		"TestBackup123": &Record{
+			Checksum:  "1f2946e2fd7d0be6c4295c1ed828f0ff4aec21e89df898f9efbaddbe445c5c7c",
+			HashAlgo:  hashes.SHA256,
+			Timestamp: 1686676137,
+			Valid:     true,
+		},
+	}
+	paths := []string{filepath.Join(dir, "a"), filepath.Join(dir, "sub", "b")}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, encoding := range []Encoding{EncodingJSON, EncodingBinary} {
+		SetEncoding(encoding)
+		for _, path := range paths {
+			if err := os.WriteFile(path, []byte(path), 0644); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if attr, err := LoadAttribute(path); err != nil || len(attr) != 0 {
+				t.Errorf("%s: Expected empty attribute for untagged file %s: %v", encoding, path, err)
+			}
+			if err := sample.Store(path); err != nil {
+				t.Fatalf("%s: Failed to store attribute for %s: %s", encoding, path, err)
+			}
+		}
+		if err := b.Close(); err != nil {
+			t.Fatalf("%s: Failed to close backend: %s", encoding, err)
+		}
+		if !b.IsStorageFile(storage) {
+			t.Errorf("%s: %s is not reported as storage file", encoding, storage)
+		}
+		if _, err := os.Stat(storage); err != nil {
+			t.Errorf("%s: Storage file %s was not written: %s", encoding, storage, err)
+		}
+		for _, path := range paths {
+			if names, err := xattr.List(path); err != nil || len(names) > 0 {
+				t.Errorf("%s: File %s has extended attributes %v: %v", encoding, path, names, err)
+			}
+			loaded, err := LoadAttribute(path)
+			if err != nil || !equalAttributes(sample, loaded) {
+				t.Errorf("%s: Attribute for %s did not load correctly: %v", encoding, path, err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if err := PurgeAttr(f); err != nil {
+				t.Errorf("%s: Failed to purge attribute for %s: %s", encoding, path, err)
+			}
+			f.Close()
+			if attr, err := LoadAttribute(path); err != nil || len(attr) != 0 {
+				t.Errorf("%s: Expected empty attribute for purged file %s: %v", encoding, path, err)
+			}
+		}
+		if err := b.Close(); err != nil {
+			t.Fatalf("%s: Failed to close backend: %s", encoding, err)
+		}
+	}
+	SetEncoding(EncodingJSON)
+}
+
+func TestSidecarBackend(t *testing.T) {
+	dir := t.TempDir()
+	testFileBackend(t, new(SidecarBackend), dir, filepath.Join(dir, SidecarName))
+	if _, err := os.Stat(filepath.Join(dir, SidecarName)); !os.IsNotExist(err) {
+		t.Errorf("Empty sidecar file was not removed: %v", err)
+	}
+}
+
+func TestManifestBackend(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "manifest.json")
+	b, err := NewManifestBackend(manifest)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	testFileBackend(t, b, dir, manifest)
+	//Files outside of the manifest's tree must be rejected
+	outside := filepath.Join(t.TempDir(), "outside")
+	if err := os.WriteFile(outside, nil, 0644); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	SetBackend(b)
+	defer SetBackend(new(XattrBackend))
+	if _, err := LoadAttribute(outside); err == nil {
+		t.Errorf("Expected error for file outside of the manifest's tree")
+	}
+}
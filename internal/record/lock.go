@@ -0,0 +1,97 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Wrapped by the error OpenLocked returns if another process holds the lock
+// of a file for longer than the lock timeout.
+var ErrLocked = errors.New("File is locked by another process")
+
+// Interval between two attempts to take a contended lock.
+const lockPollInterval = 10 * time.Millisecond
+
+var lockTimeout time.Duration
+
+// Sets how long all subsequent calls to OpenLocked wait for the lock of a
+// file. Locking is disabled if timeout is 0, which is the default.
+func SetLockTimeout(timeout time.Duration) {
+	lockTimeout = timeout
+}
+
+// Opens the file at path for a load-modify-store cycle of its attribute. If
+// locking is enabled, an exclusive advisory lock is taken that is released
+// when the file is closed. Concurrent xtagger runs that lock the same file
+// wait for each other instead of overwriting each other's records. Waits for
+// at most the timeout set by SetLockTimeout, returns an error wrapping
+// ErrLocked if the lock could not be taken in time.
+func OpenLocked(path string) (*os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f, lockTimeout); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Locks directory dir while a storage file within it is rewritten, so
+// concurrent runs don't overwrite each other's changes. The directory is
+// locked instead of the storage file, as saving replaces the storage file.
+// Returns a function that releases the lock. Does nothing if locking is
+// disabled.
+func lockDir(dir string) (unlock func() error, err error) {
+	if lockTimeout <= 0 {
+		return func() error { return nil }, nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(d, lockTimeout); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d.Close, nil
+}
+
+// Locks f, waits for at most timeout. Does nothing if timeout is 0.
+func lockFile(f *os.File, timeout time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			return fmt.Errorf("Failed to lock %s: %s", f.Name(), err)
+		}
+		if locked {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("%w: Gave up waiting for %s after %s", ErrLocked, f.Name(), timeout)
+		}
+		time.Sleep(min(remaining, lockPollInterval))
+	}
+}
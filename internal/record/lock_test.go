@@ -0,0 +1,178 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Environment variable that turns the test binary into a writer process for TestParallelWriters.
+const lockWriterEnv = "XTAGGER_TEST_LOCK_WRITER"
+
+// Environment variable that turns the test binary into a writer process for TestParallelSidecarWriters.
+const sidecarWriterEnv = "XTAGGER_TEST_SIDECAR_WRITER"
+
+// Adds records to the attribute of path, each in its own locked load-modify-store cycle.
+func addLockedRecords(path, prefix string, count int) error {
+	for i := 0; i < count; i++ {
+		f, err := OpenLocked(path)
+		if err != nil {
+			return err
+		}
+		attr, err := FLoadAttribute(f)
+		if err == nil {
+			attr[fmt.Sprintf("%s-%d", prefix, i)] = &Record{
+				Checksum:  "9c1185a5c5e9fc54612808977ee8f548b2258d31",
+				HashAlgo:  hashes.RIPEMD160,
+				Timestamp: time.Now().Unix(),
+				Valid:     true,
+			}
+			err = attr.FStore(f)
+		}
+		//Closing the file releases the lock
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestParallelWriters(t *testing.T) {
+	const writers = 8
+	const recordsPerWriter = 8
+	if prefix := os.Getenv(lockWriterEnv); prefix != "" {
+		//Running as writer process, the binary encoding keeps the attribute within xattr size limits
+		SetEncoding(EncodingBinary)
+		SetLockTimeout(time.Minute)
+		if err := addLockedRecords(os.Args[len(os.Args)-1], prefix, recordsPerWriter); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	const path = "TestParallelWriters.temp"
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("Could not create temp file: %s", err)
+	}
+	defer os.Remove(path)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestParallelWriters$", "--", path)
+		cmd.Env = append(os.Environ(), fmt.Sprintf("%s=writer%d", lockWriterEnv, i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if output, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("Writer process failed: %s\n%s", err, output)
+			}
+		}()
+	}
+	wg.Wait()
+	attr, err := LoadAttribute(path)
+	if err != nil {
+		t.Fatalf("Failed to load attribute: %s", err)
+	}
+	if len(attr) != writers*recordsPerWriter {
+		t.Errorf("Expected %d records, got %d", writers*recordsPerWriter, len(attr))
+	}
+}
+
+func TestParallelSidecarWriters(t *testing.T) {
+	const writers = 8
+	const recordsPerWriter = 8
+	if prefix := os.Getenv(sidecarWriterEnv); prefix != "" {
+		//Running as writer process, every writer tags its own file of the shared sidecar
+		SetBackend(new(SidecarBackend))
+		SetLockTimeout(time.Minute)
+		if err := addLockedRecords(os.Args[len(os.Args)-1], prefix, recordsPerWriter); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%d", i))
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Could not create temp file: %s", err)
+		}
+		cmd := exec.Command(os.Args[0], "-test.run=^TestParallelSidecarWriters$", "--", path)
+		cmd.Env = append(os.Environ(), fmt.Sprintf("%s=writer%d", sidecarWriterEnv, i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if output, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("Writer process failed: %s\n%s", err, output)
+			}
+		}()
+	}
+	wg.Wait()
+	SetBackend(new(SidecarBackend))
+	defer SetBackend(new(XattrBackend))
+	for i := 0; i < writers; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%d", i))
+		attr, err := LoadAttribute(path)
+		if err != nil {
+			t.Fatalf("Failed to load attribute: %s", err)
+		}
+		if len(attr) != recordsPerWriter {
+			t.Errorf("%s: Expected %d records, got %d", path, recordsPerWriter, len(attr))
+		}
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	const path = "TestLockTimeout.temp"
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("Could not create temp file: %s", err)
+	}
+	defer os.Remove(path)
+	SetLockTimeout(50 * time.Millisecond)
+	defer SetLockTimeout(0)
+	holder, err := OpenLocked(path)
+	if err != nil {
+		t.Fatalf("Failed to take lock: %s", err)
+	}
+	//A second open file description must not get the lock
+	start := time.Now()
+	if _, err := OpenLocked(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked, got %v", err)
+	} else if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("Gave up after %s, before the timeout", waited)
+	}
+	//Closing the file releases the lock
+	holder.Close()
+	contender, err := OpenLocked(path)
+	if err != nil {
+		t.Fatalf("Failed to take released lock: %s", err)
+	}
+	defer contender.Close()
+	//Locking is disabled without a timeout
+	SetLockTimeout(0)
+	f, err := OpenLocked(path)
+	if err != nil {
+		t.Errorf("Unexpected error with disabled locking: %s", err)
+	} else {
+		f.Close()
+	}
+}
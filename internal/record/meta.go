@@ -0,0 +1,61 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"io/fs"
+)
+
+// Stores size, modification time, device ID and inode number of info in the record.
+// Device ID and inode number are only stored on platforms that provide them.
+func (r *Record) SetFileMeta(info fs.FileInfo) {
+	r.Size = info.Size()
+	r.MTime = info.ModTime().UnixNano()
+	r.Device, r.Inode = deviceAndInode(info)
+}
+
+// Returns true if the record holds file metadata. Records created before
+// xtagger stored metadata don't have it.
+func (r *Record) HasFileMeta() bool {
+	return r.MTime != 0
+}
+
+// Returns true if the size or modification time of info differ from the
+// metadata stored in the record. Always returns false if the record has no
+// file metadata.
+func (r *Record) FileMetaChanged(info fs.FileInfo) bool {
+	if !r.HasFileMeta() {
+		return false
+	}
+	return r.Size != info.Size() || r.MTime != info.ModTime().UnixNano()
+}
+
+// Tells why the file described by info no longer matches the record's checksum.
+// Returns ReasonModified if size, modification time or inode number changed since
+// the record was created and ReasonCorrupted if they are unchanged. The device ID
+// is not compared as it is not stable across reboots for some filesystems.
+// Returns ReasonNone if the record has no file metadata.
+func (r *Record) MismatchReason(info fs.FileInfo) InvalidReason {
+	if !r.HasFileMeta() {
+		return ReasonNone
+	}
+	if r.FileMetaChanged(info) {
+		return ReasonModified
+	}
+	if _, inode := deviceAndInode(info); r.Inode != 0 && inode != r.Inode {
+		return ReasonModified
+	}
+	return ReasonCorrupted
+}
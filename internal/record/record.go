@@ -23,12 +23,37 @@ import (
 
 const attrName = "user.xtagger"
 
+// Reason why a record was invalidated.
+type InvalidReason string
+
+const (
+	ReasonNone      InvalidReason = ""          // Record is valid or the reason is unknown.
+	ReasonModified  InvalidReason = "modified"  // File was edited after tagging, size, mtime or inode differ.
+	ReasonCorrupted InvalidReason = "corrupted" // File metadata is unchanged but its content differs.
+)
+
+func (r InvalidReason) validate() error {
+	switch r {
+	case ReasonNone, ReasonModified, ReasonCorrupted:
+		return nil
+	}
+	return fmt.Errorf("Unknown invalidation reason \"%s\"", string(r))
+}
+
 // Represents a single record within a user.xtagger xattr entry
 type Record struct {
-	Checksum  string      `json:"c"` // File hash as hex string.
-	HashAlgo  hashes.Algo `json:"h"` // Name of the used hashing algorithm.
-	Timestamp int64       `json:"t"` // Unix timestamp of the record's creation.
-	Valid     bool        `json:"v"` // Record valid if true, invalidated if false.
+	Checksum  string        `json:"c"`           // File hash as hex string.
+	HashAlgo  hashes.Algo   `json:"h"`           // Name of the used hashing algorithm.
+	Timestamp int64         `json:"t"`           // Unix timestamp of the record's creation.
+	Valid     bool          `json:"v"`           // Record valid if true, invalidated if false.
+	Archive   string        `json:"a,omitempty"` // Path of the archive the file was written to, if any.
+	Size      int64         `json:"s,omitempty"` // File size in bytes at the record's creation.
+	MTime     int64         `json:"m,omitempty"` // File modification time in nanoseconds since the Unix epoch at the record's creation.
+	Device    uint64        `json:"d,omitempty"` // Device ID of the file at the record's creation.
+	Inode     uint64        `json:"i,omitempty"` // Inode number of the file at the record's creation.
+	ChunkSize int64         `json:"k,omitempty"` // Chunk size in bytes if Checksum is the root of a chunk tree, see hashes.TreeHash.
+	BlockSize int64         `json:"b,omitempty"` // Block size in bytes if per-block digests were stored next to the attribute.
+	Reason    InvalidReason `json:"r,omitempty"` // Why the record was invalidated, empty for valid records.
 }
 
 // Returns a new record with the current time as timestamp. All other member fields
@@ -60,14 +85,30 @@ func (r *Record) validate() error {
 	if err := r.HashAlgo.Validate(); err != nil {
 		return err
 	}
-	// Checks if Checksum has the correct length
-	var checksumLen int
-	switch r.HashAlgo {
-	case hashes.RIPEMD160:
-		checksumLen = 40
-	default:
-		checksumLen = 64
+	// Checks if the file size is plausible
+	if r.Size < 0 {
+		return fmt.Errorf("File size cannot be negative: %d", r.Size)
 	}
+	// Checks if the chunk size is plausible
+	if r.ChunkSize < 0 {
+		return fmt.Errorf("Chunk size cannot be negative: %d", r.ChunkSize)
+	}
+	// Checks if the block size is plausible
+	if r.BlockSize < 0 {
+		return fmt.Errorf("Block size cannot be negative: %d", r.BlockSize)
+	}
+	if r.BlockSize > 0 && r.ChunkSize > 0 {
+		return errors.New("Chunked records cannot have block digests")
+	}
+	// Checks if the invalidation reason is known and only set on invalid records
+	if err := r.Reason.validate(); err != nil {
+		return err
+	}
+	if r.Valid && r.Reason != ReasonNone {
+		return errors.New("Valid records cannot have an invalidation reason")
+	}
+	// Checks if Checksum has the correct length
+	checksumLen := r.HashAlgo.Size() * 2
 	if len(r.Checksum) != checksumLen {
 		return fmt.Errorf("Expected a checksum of %d characters for %s", checksumLen, r.HashAlgo)
 	}
@@ -20,6 +20,7 @@ import (
 	"github.com/pkg/xattr"
 	"os"
 	"testing"
+	"time"
 )
 
 func testAttributeStoreAndLoad(t *testing.T, sample Attribute) error {
@@ -67,6 +68,18 @@ func TestAttributeStoreAndLoad(t *testing.T) {
 				Valid:     true,
 			},
 		},
+		{
+			"WithMetadata": &Record{
+				Checksum:  "1f2946e2fd7d0be6c4295c1ed828f0ff4aec21e89df898f9efbaddbe445c5c7c",
+				HashAlgo:  hashes.SHA256,
+				Timestamp: 1686676137,
+				Valid:     true,
+				Size:      4096,
+				MTime:     1686676137123456789,
+				Device:    65024,
+				Inode:     9617602,
+			},
+		},
 	}
 	for i, sample := range samples {
 		if err := testAttributeStoreAndLoad(t, sample); err != nil {
@@ -120,6 +133,8 @@ func TestNegativeLoadAttribute(t *testing.T) {
 		`{"test":{"c":"368b97b0b055910d97d284f834cbf1f8d5dec95b70576c8aedf6361e6a7bbc63","h":"SHA256","t":0,"v":"false"}}`, //"v" is string instead of bool
 		`{"test":{"c":368b97b0b055910d97d284f834cbf1f8d5dec95b70576c8aedf6361e6a7bbc63,"h":"SHA256","t":0,"v":false}}`,     //"c" is int instead of string
 		`{"test":{}}`, //Record "test" is empty
+		`{"test":{"c":"368b97b0b055910d97d284f834cbf1f8d5dec95b70576c8aedf6361e6a7bbc63","h":"SHA256","t":0,"v":false,"r":"lost"}}`,     //"r" has an illegal value
+		`{"test":{"c":"368b97b0b055910d97d284f834cbf1f8d5dec95b70576c8aedf6361e6a7bbc63","h":"SHA256","t":0,"v":true,"r":"corrupted"}}`, //Valid record with a reason
 
 	}
 
@@ -143,3 +158,88 @@ func TestNegativeLoadAttribute(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadAttributeWithoutFileMeta(t *testing.T) {
+	const path = "TestLoadAttributeWithoutFileMeta.temp"
+	const sample = `{"test":{"c":"368b97b0b055910d97d284f834cbf1f8d5dec95b70576c8aedf6361e6a7bbc63","h":"SHA256","t":23,"v":true}}`
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		t.Fatalf("Could not create temp file: %s", err)
+	}
+	f.Close()
+	defer os.Remove(path)
+	if err := xattr.Set(path, attrName, []byte(sample)); err != nil {
+		t.Fatalf("Unexpected error: Failed to write extended attribute: %s", err)
+	}
+	attr, err := LoadAttribute(path)
+	if err != nil {
+		t.Fatalf("Failed to load record without file metadata: %s", err)
+	}
+	if attr["test"].HasFileMeta() {
+		t.Errorf("Record without file metadata reports metadata")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if attr["test"].FileMetaChanged(info) {
+		t.Errorf("Record without file metadata reports changed metadata")
+	}
+	attr["test"].SetFileMeta(info)
+	if attr["test"].FileMetaChanged(info) {
+		t.Errorf("Record reports changed metadata right after it was set")
+	}
+}
+
+func TestMismatchReason(t *testing.T) {
+	const path = "TestMismatchReason.temp"
+	if err := os.WriteFile(path, []byte("original"), 0644); err != nil {
+		t.Fatalf("Could not create temp file: %s", err)
+	}
+	defer os.Remove(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	rec := NewRecord()
+	if reason := rec.MismatchReason(info); reason != ReasonNone {
+		t.Errorf("Record without file metadata: Expected no reason, got %q", reason)
+	}
+	rec.SetFileMeta(info)
+	if reason := rec.MismatchReason(info); reason != ReasonCorrupted {
+		t.Errorf("Unchanged metadata: Expected %q, got %q", ReasonCorrupted, reason)
+	}
+	//Same size, different modification time
+	if err := os.Chtimes(path, time.Time{}, info.ModTime().Add(time.Second)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	touched, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if reason := rec.MismatchReason(touched); reason != ReasonModified {
+		t.Errorf("Changed modification time: Expected %q, got %q", ReasonModified, reason)
+	}
+}
+
+func TestRegisteredAlgosStoreAndLoad(t *testing.T) {
+	defer SetEncoding(EncodingJSON)
+	for _, desc := range hashes.Registered() {
+		hash := desc.Name.New()
+		hash.Write([]byte(desc.Name))
+		sample := Attribute{
+			"test": &Record{
+				Checksum:  fmt.Sprintf("%x", hash.Sum(nil)),
+				HashAlgo:  desc.Name,
+				Timestamp: 1686676137,
+				Valid:     true,
+			},
+		}
+		for _, encoding := range []Encoding{EncodingJSON, EncodingBinary} {
+			SetEncoding(encoding)
+			if err := testAttributeStoreAndLoad(t, sample); err != nil {
+				t.Errorf("%s (%s): %s", desc.Name, encoding, err)
+			}
+		}
+	}
+}
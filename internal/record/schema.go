@@ -0,0 +1,114 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Version of the payload written to user.xtagger.
+//
+// Version 1 is a bare JSON object that maps names to records.
+// Version 2 wraps the records of version 1 in an envelope holding the version.
+const SchemaVersion = 2
+
+// Versioned container for the records of an Attribute.
+type envelope struct {
+	Version int             `json:"v"`
+	Records json.RawMessage `json:"r"`
+}
+
+// Migrations upgrade a payload of the version they are indexed by to the next version.
+var migrations = map[int]func(payload []byte) ([]byte, error){
+	1: migrateV1,
+}
+
+// Wraps the bare records object of version 1 into an envelope.
+func migrateV1(payload []byte) ([]byte, error) {
+	return json.Marshal(&envelope{Version: 2, Records: payload})
+}
+
+// Returns the schema version of payload.
+func payloadVersion(payload []byte) (int, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return 0, fmt.Errorf("Failed to decode json: %s", err)
+	}
+	//Version 1 payloads may contain a record named "v", but that is always an object
+	version, ok := fields["v"]
+	if !ok || bytes.HasPrefix(bytes.TrimSpace(version), []byte("{")) {
+		return 1, nil
+	}
+	var v int
+	if err := json.Unmarshal(version, &v); err != nil {
+		return 0, fmt.Errorf("Failed to decode schema version: %s", err)
+	}
+	if v < 2 {
+		return 0, fmt.Errorf("Invalid schema version %d", v)
+	}
+	return v, nil
+}
+
+// Decodes a payload of any supported version and encoding. Older payloads are
+// upgraded in memory. Returns the Attribute and the version the payload was
+// stored with.
+func decodeAttribute(payload []byte) (Attribute, int, error) {
+	if isBinaryPayload(payload) {
+		return decodeBinary(payload)
+	}
+	version, err := payloadVersion(payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version > SchemaVersion {
+		return nil, 0, fmt.Errorf("Schema version %d is not supported by this version of xtagger, upgrade to read it", version)
+	}
+	//Apply migrations
+	for v := version; v < SchemaVersion; v++ {
+		payload, err = migrations[v](payload)
+		if err != nil {
+			return nil, 0, fmt.Errorf("Failed to migrate attribute from schema version %d: %s", v, err)
+		}
+	}
+	//Decode envelope
+	env := new(envelope)
+	if err := json.Unmarshal(payload, env); err != nil {
+		return nil, 0, fmt.Errorf("Failed to decode json: %s", err)
+	}
+	attr := make(Attribute)
+	if err := json.Unmarshal(env.Records, &attr); err != nil {
+		return nil, 0, fmt.Errorf("Failed to decode json: %s", err)
+	}
+	return attr, version, nil
+}
+
+// Encodes attr as payload of the current schema version in the encoding set
+// by SetEncoding.
+func encodeAttribute(attr Attribute) ([]byte, error) {
+	if storeEncoding == EncodingBinary {
+		return encodeBinary(attr)
+	}
+	records, err := json.Marshal(attr)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode json: %s", err)
+	}
+	payload, err := json.Marshal(&envelope{Version: SchemaVersion, Records: records})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode json: %s", err)
+	}
+	return payload, nil
+}
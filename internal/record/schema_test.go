@@ -0,0 +1,168 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"github.com/pkg/xattr"
+	"os"
+	"testing"
+)
+
+// Payloads written by older versions of xtagger, mapped to the records they must decode to.
+var legacyPayloads = map[string]Attribute{
+	`{}`: {},
+	`{"test":{"c":"368b97b0b055910d97d284f834cbf1f8d5dec95b70576c8aedf6361e6a7bbc63","h":"SHA256","t":23,"v":false}}`: {
+		"test": &Record{
+			Checksum:  "368b97b0b055910d97d284f834cbf1f8d5dec95b70576c8aedf6361e6a7bbc63",
+			HashAlgo:  hashes.SHA256,
+			Timestamp: 23,
+		},
+	},
+	`{"私はウサギです":{"c":"368b97b0b055910d97d284f834cbf1f8d5dec95b70576c8aedf6361e6a7bbc63","h":"sha3_256","t":23,"v":true},"b":{"c":"9c1185a5c5e9fc54612808977ee8f548b2258d31","h":"RIPEMD160","t":1686676137,"v":true}}`: {
+		"私はウサギです": &Record{
+			Checksum:  "368b97b0b055910d97d284f834cbf1f8d5dec95b70576c8aedf6361e6a7bbc63",
+			HashAlgo:  hashes.SHA3256,
+			Timestamp: 23,
+			Valid:     true,
+		},
+		"b": &Record{
+			Checksum:  "9c1185a5c5e9fc54612808977ee8f548b2258d31",
+			HashAlgo:  hashes.RIPEMD160,
+			Timestamp: 1686676137,
+			Valid:     true,
+		},
+	},
+	//Records named like the fields of the version 2 envelope
+	`{"v":{"c":"368b97b0b055910d97d284f834cbf1f8d5dec95b70576c8aedf6361e6a7bbc63","h":"SHA256","t":1,"v":true},"r":{"c":"1f2946e2fd7d0be6c4295c1ed828f0ff4aec21e89df898f9efbaddbe445c5c7c","h":"SHA256","t":2,"v":false}}`: {
+		"v": &Record{
+			Checksum:  "368b97b0b055910d97d284f834cbf1f8d5dec95b70576c8aedf6361e6a7bbc63",
+			HashAlgo:  hashes.SHA256,
+			Timestamp: 1,
+			Valid:     true,
+		},
+		"r": &Record{
+			Checksum:  "1f2946e2fd7d0be6c4295c1ed828f0ff4aec21e89df898f9efbaddbe445c5c7c",
+			HashAlgo:  hashes.SHA256,
+			Timestamp: 2,
+		},
+	},
+	//Version 1 with file metadata and archive path
+	`{"tape":{"c":"1f2946e2fd7d0be6c4295c1ed828f0ff4aec21e89df898f9efbaddbe445c5c7c","h":"SHA256","t":2,"v":true,"a":"/dev/nst0","s":3,"m":1792311346066772937,"d":65024,"i":9617610}}`: {
+		"tape": &Record{
+			Checksum:  "1f2946e2fd7d0be6c4295c1ed828f0ff4aec21e89df898f9efbaddbe445c5c7c",
+			HashAlgo:  hashes.SHA256,
+			Timestamp: 2,
+			Valid:     true,
+			Archive:   "/dev/nst0",
+			Size:      3,
+			MTime:     1792311346066772937,
+			Device:    65024,
+			Inode:     9617610,
+		},
+	},
+}
+
+func equalAttributes(a, b Attribute) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, rec := range a {
+		if b[name] == nil || !rec.Equals(b[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDecodeLegacyPayloads(t *testing.T) {
+	for payload, expected := range legacyPayloads {
+		attr, version, err := decodeAttribute([]byte(payload))
+		if err != nil {
+			t.Errorf("Failed to decode legacy payload %s: %s", payload, err)
+			continue
+		}
+		if version != 1 {
+			t.Errorf("Expected version 1 for legacy payload %s, got %d", payload, version)
+		}
+		if !equalAttributes(attr, expected) {
+			t.Errorf("Legacy payload %s decoded to unexpected records", payload)
+		}
+		//Re-encode with the current version and decode again
+		encoded, err := encodeAttribute(attr)
+		if err != nil {
+			t.Errorf("Failed to encode migrated payload %s: %s", payload, err)
+			continue
+		}
+		attr, version, err = decodeAttribute(encoded)
+		if err != nil {
+			t.Errorf("Failed to decode migrated payload %s: %s", encoded, err)
+			continue
+		}
+		if version != SchemaVersion {
+			t.Errorf("Expected version %d for migrated payload %s, got %d", SchemaVersion, encoded, version)
+		}
+		if !equalAttributes(attr, expected) {
+			t.Errorf("Migrated payload %s decoded to unexpected records", encoded)
+		}
+	}
+}
+
+func TestNegativeDecodeVersion(t *testing.T) {
+	samples := []string{
+		`{"v":0,"r":{}}`,
+		`{"v":1,"r":{}}`,
+		`{"v":-2,"r":{}}`,
+		`{"v":"2","r":{}}`,
+		`{"v":3,"r":{}}`, //Newer than this program
+		`{"v":2}`,
+		`{"v":2,"r":[]}`,
+	}
+	for i, sample := range samples {
+		if _, _, err := decodeAttribute([]byte(sample)); err == nil {
+			t.Errorf("Index %d: Expected error for sample \"%s\"", i, sample)
+		}
+	}
+}
+
+func TestLoadAttributeWithVersion(t *testing.T) {
+	const path = "TestLoadAttributeWithVersion.temp"
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		t.Fatalf("Could not create temp file: %s", err)
+	}
+	f.Close()
+	defer os.Remove(path)
+	for payload, expected := range legacyPayloads {
+		if err := xattr.Set(path, attrName, []byte(payload)); err != nil {
+			t.Fatalf("Unexpected error: Failed to write extended attribute: %s", err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		attr, version, err := FLoadAttributeWithVersion(f)
+		if err != nil {
+			t.Errorf("Failed to load legacy payload %s: %s", payload, err)
+		} else if version != 1 || !equalAttributes(attr, expected) {
+			t.Errorf("Legacy payload %s loaded as version %d with unexpected records", payload, version)
+		} else if err := attr.FStore(f); err != nil {
+			t.Errorf("Failed to store migrated payload %s: %s", payload, err)
+		} else if attr, version, err = FLoadAttributeWithVersion(f); err != nil || version != SchemaVersion || !equalAttributes(attr, expected) {
+			t.Errorf("Migrated payload %s did not load as version %d: %v", payload, SchemaVersion, err)
+		}
+		f.Close()
+	}
+}
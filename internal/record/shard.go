@@ -0,0 +1,273 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/pkg/xattr"
+	"hash/crc32"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Maximum size of a single shard in bytes. Payloads that don't fit into
+// user.xtagger are split into shards of this size.
+var shardSize = 2048
+
+// Payloads larger than this are sharded without trying to store them as a
+// whole, the default is the limit for a single value on Linux.
+var maxValueSize = 65536
+
+// Index payloads start with a NUL byte, which can never start a JSON payload.
+var shardIndexMagic = []byte{0, 'X', 'S'}
+
+// Shards are written to two alternating sets, so the shards of a new payload
+// never overwrite the shards the current index refers to. Set 0 keeps the
+// shard names used before sets were introduced.
+const shardSetSuffix = ".alt"
+
+// Returns the name of the extended attribute holding the shard with index i
+// of shard set set of the payload stored in extended attribute base.
+func shardName(base string, set, i int) string {
+	if set == 1 {
+		return base + shardSetSuffix + "." + strconv.Itoa(i)
+	}
+	return base + "." + strconv.Itoa(i)
+}
+
+// Returns the shard set and the shard index of an extended attribute name,
+// false if name is not the name of a shard of the payload stored in base.
+func shardIndex(base, name string) (set, i int, ok bool) {
+	suffix, found := strings.CutPrefix(name, base+".")
+	if !found {
+		return 0, 0, false
+	}
+	if alt, found := strings.CutPrefix(name, base+shardSetSuffix+"."); found {
+		set, suffix = 1, alt
+	}
+	i, err := strconv.Atoi(suffix)
+	if err != nil || i < 0 || strconv.Itoa(i) != suffix {
+		return 0, 0, false
+	}
+	return set, i, true
+}
+
+// Returns true if err was caused by exceeding the size limit for extended attributes.
+func isSizeError(err error) bool {
+	return errors.Is(err, syscall.E2BIG) || errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.ERANGE)
+}
+
+// Encodes the index stored in the base attribute of a sharded payload:
+// magic, uvarint shard count, uvarint payload length, CRC-32 of the payload,
+// shard set.
+func encodeShardIndex(set, shards int, payload []byte) []byte {
+	index := bytes.Clone(shardIndexMagic)
+	index = binary.AppendUvarint(index, uint64(shards))
+	index = binary.AppendUvarint(index, uint64(len(payload)))
+	index = binary.BigEndian.AppendUint32(index, crc32.ChecksumIEEE(payload))
+	return append(index, byte(set))
+}
+
+// Decoded content of a shard index.
+type shardIndexInfo struct {
+	set      int
+	shards   int
+	length   int
+	checksum uint32
+}
+
+// Decodes a shard index created by encodeShardIndex. Rejects indexes whose
+// payload length exceeds what its shards can hold, so the length can be
+// trusted for allocations.
+func decodeShardIndex(payload []byte) (*shardIndexInfo, error) {
+	index := bytes.NewReader(payload[len(shardIndexMagic):])
+	shards, err := binary.ReadUvarint(index)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode shard index: %s", err)
+	}
+	length, err := binary.ReadUvarint(index)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode shard index: %s", err)
+	}
+	info := new(shardIndexInfo)
+	if err := binary.Read(index, binary.BigEndian, &info.checksum); err != nil {
+		return nil, fmt.Errorf("Failed to decode shard index: %s", err)
+	}
+	set, err := index.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode shard index: %s", err)
+	}
+	info.set = int(set)
+	//Every shard name takes at least two bytes of the attribute list, which
+	//is limited to maxValueSize bytes. The shard count bounds the length.
+	if index.Len() > 0 || info.set > 1 || shards < 1 || shards > length || shards > uint64(maxValueSize) ||
+		length > shards*uint64(shardSize) || length > shards*uint64(maxValueSize) {
+		return nil, errors.New("Malformed shard index")
+	}
+	info.shards, info.length = int(shards), int(length)
+	return info, nil
+}
+
+// Reads the payload stored in extended attribute base of File f, reassembles
+// it if it is sharded. Returns an error wrapping xattr.ENOATTR if f has no payload.
+func readPayload(f *os.File, base string) ([]byte, error) {
+	payload, err := xattr.FGet(f, base)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(payload, shardIndexMagic) {
+		return payload, nil
+	}
+	index, err := decodeShardIndex(payload)
+	if err != nil {
+		return nil, err
+	}
+	//Reassemble payload
+	payload = make([]byte, 0, index.length)
+	for i := 0; i < index.shards; i++ {
+		shard, err := xattr.FGet(f, shardName(base, index.set, i))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read shard %d: %s", i, err)
+		}
+		if len(payload)+len(shard) > index.length {
+			return nil, errors.New("Sharded attribute is corrupted, shards exceed the length in their index")
+		}
+		payload = append(payload, shard...)
+	}
+	if len(payload) != index.length || crc32.ChecksumIEEE(payload) != index.checksum {
+		return nil, errors.New("Sharded attribute is corrupted, shards don't match their index")
+	}
+	return payload, nil
+}
+
+// Returns the decoded index in extended attribute base of File f, nil if base
+// does not hold a valid index.
+func currentShardIndex(f *os.File, base string) *shardIndexInfo {
+	payload, err := xattr.FGet(f, base)
+	if err != nil || !bytes.HasPrefix(payload, shardIndexMagic) {
+		return nil
+	}
+	index, err := decodeShardIndex(payload)
+	if err != nil {
+		return nil
+	}
+	return index
+}
+
+// Returns the shard set the index in extended attribute base of File f refers
+// to, -1 if base does not hold a valid index.
+func currentShardSet(f *os.File, base string) int {
+	if index := currentShardIndex(f, base); index != nil {
+		return index.set
+	}
+	return -1
+}
+
+// Writes payload to extended attribute base of File f. If payload does not
+// fit into a single extended attribute, it is split into shards. Shards are
+// written to the set the current index does not refer to, then the index is
+// replaced, so an interrupted or failed write leaves the previous payload
+// intact. If the filesystem has no room for both shard sets and payload is not
+// larger than the current one, the current set is rewritten in place, an
+// interrupted write then corrupts the payload.
+// Shards left over from previous writes are removed.
+func writePayload(f *os.File, base string, payload []byte) error {
+	set, shards := 0, 0
+	var err error
+	if len(payload) > maxValueSize {
+		err = syscall.E2BIG
+	} else {
+		err = xattr.FSet(f, base, payload)
+	}
+	if err != nil {
+		if !isSizeError(err) {
+			return err
+		}
+		current := currentShardIndex(f, base)
+		if current != nil && current.set == 0 {
+			set = 1
+		}
+		shards, err = writeShardSet(f, base, set, payload)
+		if err != nil {
+			//The new shards are not referenced by any index
+			if cleanupErr := removeShardSet(f, base, set); cleanupErr != nil || !isSizeError(err) ||
+				current == nil || len(payload) > current.length {
+				return errors.Join(err, cleanupErr)
+			}
+			//No room for a second shard set, the payload fits into the space of the current one
+			set = current.set
+			if shards, err = writeShardSet(f, base, set, payload); err != nil {
+				return err
+			}
+		}
+		if err := xattr.FSet(f, base, encodeShardIndex(set, shards, payload)); err != nil {
+			return errors.Join(err, removeShardSet(f, base, set))
+		}
+	}
+	return removeShards(f, base, set, shards)
+}
+
+// Writes payload as shards of shard set set of extended attribute base of
+// File f. Returns the number of shards.
+func writeShardSet(f *os.File, base string, set int, payload []byte) (int, error) {
+	shards := 0
+	for offset := 0; offset < len(payload); offset += shardSize {
+		end := min(offset+shardSize, len(payload))
+		if err := xattr.FSet(f, shardName(base, set, shards), payload[offset:end]); err != nil {
+			return 0, err
+		}
+		shards++
+	}
+	return shards, nil
+}
+
+// Removes all shards of the payload in extended attribute base of File f,
+// except for the first keep shards of shard set set.
+func removeShards(f *os.File, base string, set, keep int) error {
+	names, err := xattr.FList(f)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if s, i, ok := shardIndex(base, name); ok && (s != set || i >= keep) {
+			if err := xattr.FRemove(f, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Removes all shards of shard set set of the payload in extended attribute
+// base of File f.
+func removeShardSet(f *os.File, base string, set int) error {
+	names, err := xattr.FList(f)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if s, _, ok := shardIndex(base, name); ok && s == set {
+			if err := xattr.FRemove(f, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
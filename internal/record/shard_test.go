@@ -0,0 +1,276 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/hashes"
+	"github.com/pkg/xattr"
+	"os"
+	"slices"
+	"testing"
+)
+
+// Returns the names of the shards of path.
+func listShards(t *testing.T, path string) []string {
+	names, err := xattr.List(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	shards := make([]string, 0)
+	for _, name := range names {
+		if _, _, ok := shardIndex(attrName, name); ok {
+			shards = append(shards, name)
+		}
+	}
+	slices.Sort(shards)
+	return shards
+}
+
+func TestShardedAttribute(t *testing.T) {
+	const path = "TestShardedAttribute.temp"
+	defer func(size, max int) {
+		shardSize = size
+		maxValueSize = max
+	}(shardSize, maxValueSize)
+	shardSize = 64
+	maxValueSize = 128
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		t.Fatalf("Could not create temp file: %s", err)
+	}
+	f.Close()
+	defer os.Remove(path)
+
+	large := make(Attribute)
+	for i := 0; i < 5; i++ {
+		large[fmt.Sprintf("backup%d", i)] = &Record{
+			Checksum:  "1f2946e2fd7d0be6c4295c1ed828f0ff4aec21e89df898f9efbaddbe445c5c7c",
+			HashAlgo:  hashes.SHA256,
+			Timestamp: int64(i),
+			Valid:     true,
+		}
+	}
+	//Store sharded attribute
+	if err := testAttributeStoreAndLoad(t, large); err != nil {
+		t.Fatalf("Sharded attribute: %s", err)
+	}
+	if err := large.Store(path); err != nil {
+		t.Fatalf("Failed to store sharded attribute: %s", err)
+	}
+	shards := listShards(t, path)
+	if len(shards) < 2 {
+		t.Fatalf("Expected multiple shards, got %v", shards)
+	}
+	//Shrink attribute, stale shards must be removed
+	small := large.FilterByName("backup0")
+	if err := small.Store(path); err != nil {
+		t.Fatalf("Failed to store small attribute: %s", err)
+	}
+	if shards := listShards(t, path); len(shards) > 0 {
+		t.Errorf("Stale shards left after storing a small attribute: %v", shards)
+	}
+	loaded, err := LoadAttribute(path)
+	if err != nil || !equalAttributes(small, loaded) {
+		t.Errorf("Small attribute did not load correctly: %v", err)
+	}
+	//Corrupt a shard
+	if err := large.Store(path); err != nil {
+		t.Fatalf("Failed to store sharded attribute: %s", err)
+	}
+	if err := xattr.Set(path, shardName(attrName, 0, 1), []byte("corrupted")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err := LoadAttribute(path); err == nil {
+		t.Errorf("Expected error for corrupted shard")
+	}
+	//Purge must remove the index and all shards
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer f.Close()
+	if err := PurgeAttr(f); err != nil {
+		t.Fatalf("Failed to purge attribute: %s", err)
+	}
+	names, err := xattr.List(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(names) > 0 {
+		t.Errorf("Extended attributes left after purge: %v", names)
+	}
+}
+
+func TestShardIndex(t *testing.T) {
+	tests := map[string]bool{
+		"user.xtagger.0":         true,
+		"user.xtagger.12":        true,
+		"user.xtagger":           false,
+		"user.xtagger.":          false,
+		"user.xtagger.01":        false,
+		"user.xtagger.-1":        false,
+		"user.xtagger.a":         false,
+		"user.xtaggerx.1":        false,
+		"user.other.1":           false,
+		"user.xtagger.1.2":       false,
+		"user.xtagger.digests.0": false,
+		"user.xtagger.alt.0":     true,
+		"user.xtagger.alt.12":    true,
+		"user.xtagger.alt":       false,
+		"user.xtagger.alt.01":    false,
+		"user.xtagger.alt.alt.0": false,
+	}
+	for name, expected := range tests {
+		if _, _, ok := shardIndex(attrName, name); ok != expected {
+			t.Errorf("shardIndex(%q): expected %t, got %t", name, expected, ok)
+		}
+	}
+}
+
+func TestShardSets(t *testing.T) {
+	const path = "TestShardSets.temp"
+	defer func(size, max int) {
+		shardSize = size
+		maxValueSize = max
+	}(shardSize, maxValueSize)
+	shardSize = 64
+	maxValueSize = 128
+
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("Could not create temp file: %s", err)
+	}
+	defer os.Remove(path)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer f.Close()
+	first := bytes.Repeat([]byte("a"), 300)
+	second := bytes.Repeat([]byte("b"), 200)
+	//Consecutive sharded payloads alternate between the shard sets
+	for i, payload := range [][]byte{first, second, first} {
+		if err := writePayload(f, attrName, payload); err != nil {
+			t.Fatalf("Payload %d: Failed to write: %s", i, err)
+		}
+		set := currentShardSet(f, attrName)
+		if set != i%2 {
+			t.Errorf("Payload %d: Expected shard set %d, got %d", i, i%2, set)
+		}
+		for _, name := range listShards(t, path) {
+			if s, _, _ := shardIndex(attrName, name); s != set {
+				t.Errorf("Payload %d: Shard %s of the previous payload was not removed", i, name)
+			}
+		}
+		if loaded, err := readPayload(f, attrName); err != nil || !bytes.Equal(loaded, payload) {
+			t.Errorf("Payload %d: Did not read back correctly: %v", i, err)
+		}
+	}
+	//An interrupted write only touches the unused set, the current payload stays readable
+	for i := 0; i < 3; i++ {
+		if err := xattr.Set(path, shardName(attrName, 1, i), []byte("interrupted")); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	if loaded, err := readPayload(f, attrName); err != nil || !bytes.Equal(loaded, first) {
+		t.Errorf("Payload did not survive an interrupted write: %v", err)
+	}
+}
+
+func TestMalformedShardIndex(t *testing.T) {
+	const path = "TestMalformedShardIndex.temp"
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("Could not create temp file: %s", err)
+	}
+	defer os.Remove(path)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer f.Close()
+	index := func(shards, length uint64, tail ...byte) []byte {
+		payload := binary.AppendUvarint(bytes.Clone(shardIndexMagic), shards)
+		payload = binary.AppendUvarint(payload, length)
+		return append(binary.BigEndian.AppendUint32(payload, 0), tail...)
+	}
+	samples := [][]byte{
+		index(1, 1<<62, 0),                      //Length exceeds what a shard can hold
+		index(1<<40, 1<<62, 0),                  //Shard count exceeds the attribute list
+		index(0, 0, 0),                          //No shards
+		index(2, 1, 0),                          //More shards than bytes
+		index(1, 10, 2),                         //Unknown shard set
+		index(1, 10, 0, 0),                      //Trailing data
+		append(bytes.Clone(shardIndexMagic), 1), //Truncated
+	}
+	for i, sample := range samples {
+		if err := xattr.FSet(f, attrName, sample); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if _, err := readPayload(f, attrName); err == nil {
+			t.Errorf("Index %d: Expected error for sample %v", i, sample)
+		}
+	}
+
+}
+
+func TestShardSetsWithoutRoom(t *testing.T) {
+	const path = "TestShardSetsWithoutRoom.temp"
+	defer func(size, max int) {
+		shardSize = size
+		maxValueSize = max
+	}(shardSize, maxValueSize)
+	shardSize = 64
+	maxValueSize = 128
+
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("Could not create temp file: %s", err)
+	}
+	defer os.Remove(path)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer f.Close()
+	//Find the largest payload the filesystem can store as a single shard set
+	var size int
+	for size = 256; size <= 1<<20; size += 256 {
+		if err := new(XattrBackend).RemovePayload(f); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if err := writePayload(f, attrName, bytes.Repeat([]byte("a"), size)); err != nil {
+			break
+		}
+	}
+	if size > 1<<20 {
+		t.Skip("Filesystem has no tight limit for extended attributes")
+	}
+	size -= 256
+	if err := writePayload(f, attrName, bytes.Repeat([]byte("a"), size)); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	//Rewriting a payload of that size has no room for a second set
+	for i, b := range []byte("bcd") {
+		payload := bytes.Repeat([]byte{b}, size)
+		if err := writePayload(f, attrName, payload); err != nil {
+			t.Fatalf("Rewrite %d: Failed to write: %s", i, err)
+		}
+		if loaded, err := readPayload(f, attrName); err != nil || !bytes.Equal(loaded, payload) {
+			t.Errorf("Rewrite %d: Did not read back correctly: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pkg/xattr"
+	"os"
+	"strings"
+)
+
+// Extended attribute holding the digest payload.
+const digestsAttrName = attrName + ".digests"
+
+// XattrBackend stores payloads in the extended attribute user.xtagger and
+// digest payloads in user.xtagger.digests, oversized payloads are sharded.
+type XattrBackend struct{}
+
+func (r *XattrBackend) ReadPayload(f *os.File) ([]byte, error) {
+	payload, err := readPayload(f, attrName)
+	if errors.Is(err, xattr.ENOATTR) {
+		return nil, fmt.Errorf("%w: %s", ErrNoAttribute, err)
+	}
+	return payload, err
+}
+
+func (r *XattrBackend) WritePayload(f *os.File, payload []byte) error {
+	return writePayload(f, attrName, payload)
+}
+
+func (r *XattrBackend) ReadDigests(f *os.File) ([]byte, error) {
+	payload, err := readPayload(f, digestsAttrName)
+	if errors.Is(err, xattr.ENOATTR) {
+		return nil, fmt.Errorf("%w: %s", ErrNoAttribute, err)
+	}
+	return payload, err
+}
+
+func (r *XattrBackend) WriteDigests(f *os.File, payload []byte) error {
+	if len(payload) == 0 {
+		if err := xattr.FRemove(f, digestsAttrName); err != nil && !errors.Is(err, xattr.ENOATTR) {
+			return err
+		}
+		return removeShards(f, digestsAttrName, 0, 0)
+	}
+	//A failed write leaves the previous digest payload intact
+	return writePayload(f, digestsAttrName, payload)
+}
+
+// Removes xtagger's extended attributes and all of their shards.
+func (r *XattrBackend) RemovePayload(f *os.File) error {
+	attrNames, err := xattr.FList(f)
+	if err != nil {
+		return err
+	}
+	for _, name := range attrNames {
+		if name == attrName || strings.HasPrefix(name, attrName+".") {
+			if err := xattr.FRemove(f, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *XattrBackend) IsStorageFile(path string) bool {
+	return false
+}
+
+func (r *XattrBackend) Close() error {
+	return nil
+}
@@ -38,7 +38,7 @@ func Consume(err error) error {
 	//Consume soft error
 	if err != nil {
 		slog.Error(err.Error())
-		global.ExitCode = global.ExitSoftError
+		global.SetExitCode(global.ExitSoftError)
 	}
 	return nil
 }
@@ -49,7 +49,7 @@ func Errorf(format string, a ...any) error {
 	if !stopOnSoftError {
 		//Consume soft error
 		slog.Error(fmt.Sprintf(format, a...))
-		global.ExitCode = global.ExitSoftError
+		global.SetExitCode(global.ExitSoftError)
 		return nil
 	}
 	return fmt.Errorf(format, a...)
@@ -0,0 +1,30 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package xio
+
+// CountingWriter discards all data written to it and counts the bytes.
+type CountingWriter struct {
+	n int64
+}
+
+func (r *CountingWriter) Write(p []byte) (n int, err error) {
+	r.n += int64(len(p))
+	return len(p), nil
+}
+
+// Count returns the amount of bytes written so far.
+func (r *CountingWriter) Count() int64 {
+	return r.n
+}
@@ -0,0 +1,278 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Number of entries read from a directory at once.
+const dirBatchSize = 1024
+
+// Maximum number of entries a sorted walk keeps in memory per directory.
+// Larger directories are sorted in runs on disk that are merged while the
+// directory is walked.
+var sortRunSize = 1 << 16
+
+// Reads the entries of a directory batch by batch.
+type dirReader interface {
+	// Returns the next batch of entries. The batch may come with an error,
+	// io.EOF is returned with or after the last batch.
+	next() ([]fs.DirEntry, error)
+	close() error
+}
+
+// Reads the entries of a directory in directory order.
+type streamReader struct {
+	f *os.File
+}
+
+func (r *streamReader) next() ([]fs.DirEntry, error) {
+	if r.f == nil {
+		return nil, io.EOF
+	}
+	dirEnts, err := r.f.ReadDir(dirBatchSize)
+	//os.File.ReadDir only returns a short batch at the end of the directory,
+	//close it early to save file descriptors while subdirectories are walked
+	if err != nil || len(dirEnts) < dirBatchSize {
+		r.close()
+		if err == nil {
+			err = io.EOF
+		}
+	}
+	return dirEnts, err
+}
+
+func (r *streamReader) close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// Entry of a sorted directory. The FileInfo is read on demand.
+type sortedDirEntry struct {
+	parent string
+	name   string
+	typ    fs.FileMode
+}
+
+func (r *sortedDirEntry) Name() string {
+	return r.name
+}
+
+func (r *sortedDirEntry) IsDir() bool {
+	return r.typ.IsDir()
+}
+
+func (r *sortedDirEntry) Type() fs.FileMode {
+	return r.typ
+}
+
+func (r *sortedDirEntry) Info() (fs.FileInfo, error) {
+	return os.Lstat(filepath.Join(r.parent, r.name))
+}
+
+func (r *sortedDirEntry) String() string {
+	return fs.FormatDirEntry(r)
+}
+
+func compareEntries(a, b *sortedDirEntry) int {
+	return strings.Compare(a.name, b.name)
+}
+
+// Run of sorted entries in a temporary file.
+type sortRun struct {
+	f      *os.File
+	r      *bufio.Reader
+	parent string
+	head   *sortedDirEntry //Next entry of the run, nil if the run is exhausted
+}
+
+// Writes dirEnts of the directory parent to a new run in tmpDir.
+func writeRun(tmpDir, parent string, dirEnts []*sortedDirEntry) (*sortRun, error) {
+	f, err := os.CreateTemp(tmpDir, "xtagger-sort-")
+	if err != nil {
+		return nil, err
+	}
+	run := &sortRun{f: f, parent: parent}
+	w := bufio.NewWriter(f)
+	buf := make([]byte, binary.MaxVarintLen32)
+	for _, dirEnt := range dirEnts {
+		//Entry: Type as uvarint, then the name terminated by a null byte
+		w.Write(buf[:binary.PutUvarint(buf, uint64(dirEnt.typ))])
+		w.WriteString(dirEnt.name)
+		w.WriteByte(0)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, errors.Join(err, run.close())
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Join(err, run.close())
+	}
+	run.r = bufio.NewReader(f)
+	return run, nil
+}
+
+// Reads the next entry of the run into head.
+func (r *sortRun) advance() error {
+	typ, err := binary.ReadUvarint(r.r)
+	if err == io.EOF {
+		r.head = nil
+		return nil
+	} else if err != nil {
+		return err
+	}
+	name, err := r.r.ReadString(0)
+	if err != nil {
+		return errors.Join(errors.New("Truncated sort run"), err)
+	}
+	r.head = &sortedDirEntry{parent: r.parent, name: name[:len(name)-1], typ: fs.FileMode(typ)}
+	return nil
+}
+
+func (r *sortRun) close() error {
+	return errors.Join(r.f.Close(), os.Remove(r.f.Name()))
+}
+
+// Min-heap of runs ordered by their heads.
+type runHeap []*sortRun
+
+func (r runHeap) Len() int           { return len(r) }
+func (r runHeap) Less(i, j int) bool { return compareEntries(r[i].head, r[j].head) < 0 }
+func (r runHeap) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r *runHeap) Push(x any)        { *r = append(*r, x.(*sortRun)) }
+func (r *runHeap) Pop() any {
+	old := *r
+	run := old[len(old)-1]
+	*r = old[:len(old)-1]
+	return run
+}
+
+// Reads the entries of a directory sorted by name. Directories with up to
+// sortRunSize entries are sorted in memory, larger ones are merged from
+// sorted runs on disk.
+type sortedReader struct {
+	dirEnts []*sortedDirEntry //Entries sorted in memory
+	runs    []*sortRun        //All runs, closed by close
+	merge   runHeap           //Runs that are not exhausted
+	readErr error             //Error that stopped reading the directory, returned after the last batch
+}
+
+// Reads the directory f at path completely and sorts its entries. Temporary
+// files are created in tmpDir, or in the default directory for temporary
+// files if tmpDir is empty. f is closed.
+func newSortedReader(path string, f *os.File, tmpDir string) (*sortedReader, error) {
+	stream := &streamReader{f: f}
+	defer stream.close()
+	r := new(sortedReader)
+	buf := make([]*sortedDirEntry, 0, min(sortRunSize, dirBatchSize))
+	for {
+		dirEnts, err := stream.next()
+		for _, dirEnt := range dirEnts {
+			buf = append(buf, &sortedDirEntry{parent: path, name: dirEnt.Name(), typ: dirEnt.Type()})
+			if len(buf) >= sortRunSize {
+				slices.SortFunc(buf, compareEntries)
+				run, err := writeRun(tmpDir, path, buf)
+				if err != nil {
+					return nil, errors.Join(err, r.close())
+				}
+				r.runs = append(r.runs, run)
+				buf = buf[:0]
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			r.readErr = err
+			break
+		}
+	}
+	slices.SortFunc(buf, compareEntries)
+	if len(r.runs) == 0 {
+		r.dirEnts = buf
+		return r, nil
+	}
+	if len(buf) > 0 {
+		run, err := writeRun(tmpDir, path, buf)
+		if err != nil {
+			return nil, errors.Join(err, r.close())
+		}
+		r.runs = append(r.runs, run)
+	}
+	for _, run := range r.runs {
+		if err := run.advance(); err != nil {
+			return nil, errors.Join(err, r.close())
+		}
+		if run.head != nil {
+			r.merge = append(r.merge, run)
+		}
+	}
+	heap.Init(&r.merge)
+	return r, nil
+}
+
+func (r *sortedReader) next() ([]fs.DirEntry, error) {
+	batch := make([]fs.DirEntry, 0, dirBatchSize)
+	if r.runs == nil {
+		n := min(len(r.dirEnts), dirBatchSize)
+		for _, dirEnt := range r.dirEnts[:n] {
+			batch = append(batch, dirEnt)
+		}
+		r.dirEnts = r.dirEnts[n:]
+	} else {
+		for len(batch) < dirBatchSize && len(r.merge) > 0 {
+			run := r.merge[0]
+			batch = append(batch, run.head)
+			if err := run.advance(); err != nil {
+				return batch, err
+			}
+			if run.head == nil {
+				heap.Pop(&r.merge)
+			} else {
+				heap.Fix(&r.merge, 0)
+			}
+		}
+	}
+	if len(r.dirEnts) == 0 && len(r.merge) == 0 {
+		if r.readErr != nil {
+			return batch, r.readErr
+		}
+		return batch, io.EOF
+	}
+	return batch, nil
+}
+
+func (r *sortedReader) close() error {
+	errs := make([]error, 0)
+	for _, run := range r.runs {
+		errs = append(errs, run.close())
+	}
+	r.runs = nil
+	r.merge = nil
+	r.dirEnts = nil
+	return errors.Join(errs...)
+}
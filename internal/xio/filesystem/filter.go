@@ -0,0 +1,316 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Selects the files a walk hands to its FileExaminer. Excluded directories
+// are pruned, their contents are never read. Patterns can be added until the
+// walk starts.
+type Filter struct {
+	includes   []matcher
+	excludes   []matcher
+	ignoreFile string
+}
+
+type matcher interface {
+	match(path string, isDir bool) bool
+}
+
+// Selects files that match the glob pattern. If includes are set, files that
+// match none of them are skipped. Includes never prune directories.
+func (r *Filter) Include(pattern string) error {
+	glob, err := compileGlob(pattern)
+	if err != nil {
+		return err
+	}
+	r.includes = append(r.includes, glob)
+	return nil
+}
+
+// Selects files that match the regular expression expr, see Include.
+func (r *Filter) IncludeRegexp(expr string) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	r.includes = append(r.includes, regexpMatcher{re})
+	return nil
+}
+
+// Skips files and prunes directories that match the glob pattern.
+func (r *Filter) Exclude(pattern string) error {
+	glob, err := compileGlob(pattern)
+	if err != nil {
+		return err
+	}
+	r.excludes = append(r.excludes, glob)
+	return nil
+}
+
+// Skips files and prunes directories that match the regular expression expr.
+func (r *Filter) ExcludeRegexp(expr string) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	r.excludes = append(r.excludes, regexpMatcher{re})
+	return nil
+}
+
+// Makes the walk read ignore files with the given base name in every
+// directory. Their patterns follow the syntax of .gitignore files.
+func (r *Filter) SetIgnoreFile(name string) error {
+	if name == "" || strings.ContainsRune(name, filepath.Separator) {
+		return fmt.Errorf("Invalid ignore file name: \"%s\"", name)
+	}
+	r.ignoreFile = name
+	return nil
+}
+
+// Returns true if the directory at path is not walked.
+func (r *Filter) prunes(path string, ignores *ignoreList) bool {
+	return r.excluded(path, true, ignores)
+}
+
+// Returns true if the file at path is handed to the FileExaminer.
+func (r *Filter) selects(path string, ignores *ignoreList) bool {
+	if r.excluded(path, false, ignores) {
+		return false
+	}
+	if len(r.includes) == 0 {
+		return true
+	}
+	for _, m := range r.includes {
+		if m.match(path, false) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Filter) excluded(path string, isDir bool, ignores *ignoreList) bool {
+	for _, m := range r.excludes {
+		if m.match(path, isDir) {
+			return true
+		}
+	}
+	return ignores.ignores(path, isDir)
+}
+
+// Reads the ignore file of the directory dir if there is one. Returns the
+// ignore rules in effect for dir's entries.
+func (r *Filter) loadIgnoreFile(dir string, parent *ignoreList) (*ignoreList, error) {
+	if r.ignoreFile == "" {
+		return parent, nil
+	}
+	f, err := os.Open(filepath.Join(dir, r.ignoreFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return parent, nil
+	} else if err != nil {
+		return parent, err
+	}
+	defer f.Close()
+	rules := make([]ignoreRule, 0)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		rule, ok, err := parseIgnoreRule(scanner.Text())
+		if err != nil {
+			return parent, fmt.Errorf("%s, line %d: %s", f.Name(), line, err)
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return parent, err
+	}
+	if len(rules) == 0 {
+		return parent, nil
+	}
+	return &ignoreList{dir: dir, rules: rules, parent: parent}, nil
+}
+
+// Matches paths against a regular expression.
+type regexpMatcher struct {
+	re *regexp.Regexp
+}
+
+func (r regexpMatcher) match(path string, isDir bool) bool {
+	return r.re.MatchString(path)
+}
+
+// Glob pattern. "*" and "?" don't match a slash, "**" matches any number of
+// directories. A trailing slash restricts the pattern to directories.
+type globPattern struct {
+	re       *regexp.Regexp
+	anchored bool //Pattern contains a slash and matches the whole path, otherwise it matches the base name
+	dirOnly  bool
+}
+
+func compileGlob(pattern string) (*globPattern, error) {
+	glob := new(globPattern)
+	if strings.HasSuffix(pattern, "/") && len(pattern) > 1 {
+		glob.dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	if pattern == "" {
+		return nil, errors.New("Empty glob pattern")
+	}
+	glob.anchored = strings.Contains(pattern, "/")
+	expr, err := globToRegexp(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid glob pattern \"%s\": %s", pattern, err)
+	}
+	if glob.re, err = regexp.Compile(expr); err != nil {
+		return nil, fmt.Errorf("Invalid glob pattern \"%s\": %s", pattern, err)
+	}
+	return glob, nil
+}
+
+func (r *globPattern) match(p string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	p = filepath.ToSlash(p)
+	if !r.anchored {
+		p = path.Base(p)
+	}
+	return r.re.MatchString(p)
+}
+
+// Translates a glob pattern into an anchored regular expression.
+func globToRegexp(pattern string) (string, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				i++
+				if i+1 < len(runes) && runes[i+1] == '/' {
+					//Leading or inner "**/" also matches no directory at all
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			j := i + 1
+			if j < len(runes) && (runes[j] == '!' || runes[j] == '^') {
+				j++
+			}
+			if j < len(runes) && runes[j] == ']' {
+				j++
+			}
+			for j < len(runes) && runes[j] != ']' {
+				j++
+			}
+			if j >= len(runes) {
+				return "", errors.New("Unterminated character class")
+			}
+			class := string(runes[i+1 : j])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = j
+		case '\\':
+			if i+1 >= len(runes) {
+				return "", errors.New("Trailing backslash")
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(string(runes[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String(), nil
+}
+
+// Pattern of an ignore file.
+type ignoreRule struct {
+	*globPattern
+	negate bool //Pattern re-includes paths ignored by previous patterns
+}
+
+// Parses a line of an ignore file. Returns false if the line holds no rule.
+func parseIgnoreRule(line string) (ignoreRule, bool, error) {
+	var rule ignoreRule
+	//Trailing spaces are ignored unless they are escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false, nil
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	//A leading slash anchors the pattern to the directory of the ignore file
+	anchored := strings.HasPrefix(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	glob, err := compileGlob(line)
+	if err != nil {
+		return rule, false, err
+	}
+	glob.anchored = glob.anchored || anchored
+	rule.globPattern = glob
+	return rule, true, nil
+}
+
+// Rules of the ignore files of a directory and its ancestors.
+type ignoreList struct {
+	dir    string //Directory of the ignore file
+	rules  []ignoreRule
+	parent *ignoreList
+}
+
+// Returns true if path is ignored. Rules of deeper ignore files take
+// precedence, within a file the last matching rule wins.
+func (r *ignoreList) ignores(path string, isDir bool) bool {
+	for l := r; l != nil; l = l.parent {
+		rel, err := filepath.Rel(l.dir, path)
+		if err != nil {
+			continue
+		}
+		for i := len(l.rules) - 1; i >= 0; i-- {
+			if l.rules[i].match(rel, isDir) {
+				return !l.rules[i].negate
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestGlobPattern(t *testing.T) {
+	type test struct {
+		pattern string
+		path    string
+		isDir   bool
+		matches bool
+	}
+	tests := []test{
+		{"*.log", "/var/log/syslog.log", false, true},
+		{"*.log", "/var/log/syslog", false, false},
+		{"*.log", "log.d/x", false, false},
+		{"?.txt", "dir/a.txt", false, true},
+		{"?.txt", "dir/ab.txt", false, false},
+		{"[ab].txt", "b.txt", false, true},
+		{"[!ab].txt", "b.txt", false, false},
+		{"[!ab].txt", "c.txt", false, true},
+		{"cache/", "/home/cache", true, true},
+		{"cache/", "/home/cache", false, false},
+		{"/home/*", "/home/user", true, true},
+		{"/home/*", "/home/user/file", false, false},
+		{"**/tmp/*", "/a/b/tmp/file", false, true},
+		{"**/tmp/*", "tmp/file", false, true},
+		{"/home/**", "/home/a/b/c", false, true},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**/b", "a/x/y/c", false, false},
+		{`\*.txt`, "*.txt", false, true},
+		{`\*.txt`, "a.txt", false, false},
+		{"ünï?ode", "/ünïcode", false, true},
+	}
+	for _, test := range tests {
+		glob, err := compileGlob(test.pattern)
+		if err != nil {
+			t.Errorf("Pattern \"%s\": Unexpected error: %s", test.pattern, err)
+			continue
+		}
+		if glob.match(test.path, test.isDir) != test.matches {
+			t.Errorf("Pattern \"%s\", path \"%s\": Expected match to be %t", test.pattern, test.path, test.matches)
+		}
+	}
+	for _, pattern := range []string{"", "[ab", `abc\`} {
+		if _, err := compileGlob(pattern); err == nil {
+			t.Errorf("Expected an error for pattern \"%s\"", pattern)
+		}
+	}
+}
+
+func TestFilterSelects(t *testing.T) {
+	filter := new(Filter)
+	for _, pattern := range []string{"*.jpg", "*.png"} {
+		if err := filter.Include(pattern); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	if err := filter.Exclude("thumbs/"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := filter.ExcludeRegexp(`/\.[^/]*$`); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	selected := map[string]bool{
+		"/photos/a.jpg":  true,
+		"/photos/b.png":  true,
+		"/photos/c.gif":  false,
+		"/photos/.d.jpg": false,
+	}
+	for path, expected := range selected {
+		if filter.selects(path, nil) != expected {
+			t.Errorf("Path \"%s\": Expected selection to be %t", path, expected)
+		}
+	}
+	//Includes never prune directories
+	if filter.prunes("/photos", nil) {
+		t.Error("Directory /photos must not be pruned")
+	}
+	if !filter.prunes("/photos/thumbs", nil) {
+		t.Error("Directory /photos/thumbs must be pruned")
+	}
+	if err := filter.IncludeRegexp("("); err == nil {
+		t.Error("Expected an error for an invalid regular expression")
+	}
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".ignore":          "# Comment\n\n*.tmp\n/build/\nlogs/\n!keep.tmp\n",
+		"a.tmp":            "",
+		"keep.tmp":         "",
+		"file":             "",
+		"build/out":        "",
+		"src/build/out":    "",
+		"src/logs/1":       "",
+		"src/.ignore":      "!b.tmp\nsecret\n",
+		"src/b.tmp":        "",
+		"src/secret":       "",
+		"src/other/secret": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	expected := []string{".ignore", "file", "keep.tmp", "src/.ignore", "src/b.tmp", "src/build/out"}
+	filter := new(Filter)
+	if err := filter.SetIgnoreFile(".ignore"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, walkers := range []int{0, 4} {
+		for _, ordered := range []bool{false, true} {
+			paths := make([]string, 0)
+			for _, path := range walkPaths(t, root, &Context{Filter: filter, Walkers: walkers, Ordered: ordered}) {
+				rel, _ := filepath.Rel(root, path)
+				paths = append(paths, filepath.ToSlash(rel))
+			}
+			slices.Sort(paths)
+			if slices.Compare(paths, expected) != 0 {
+				t.Errorf("Walkers %d, ordered %t: Expected %v, got %v", walkers, ordered, expected, paths)
+			}
+		}
+	}
+	if err := filter.SetIgnoreFile("a/b"); err == nil {
+		t.Error("Expected an error for an ignore file name with a separator")
+	}
+}
+
+func TestFilterPrunesBeforeQuota(t *testing.T) {
+	root := t.TempDir()
+	createTree(t, root, 2, 5, 100)
+	//Walking the symlink loop would fail, pruning must prevent reading it
+	if err := os.Symlink(".", filepath.Join(root, "loop")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	filter := new(Filter)
+	if err := filter.Exclude("loop"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := filter.Exclude("sub/"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := filter.Include("file[0-1]"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	opts := &Context{Filter: filter, SymlinkMode: SymlinksRejectNone}
+	opts.SetQuota(QuotaCutoff, 2*2*100)
+	var examined int
+	if err := WalkDir(root, opts, func(parent string, info fs.FileInfo) error {
+		examined++
+		return nil
+	}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	//Filtered files don't count towards the quota
+	if examined != 2*2 {
+		t.Errorf("Expected %d examined files, got %d", 2*2, examined)
+	}
+}
@@ -0,0 +1,32 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !linux
+
+package filesystem
+
+import (
+	"os"
+)
+
+// OpenReadOnly opens path for reading.
+func OpenReadOnly(path string) (*os.File, error) {
+	return os.Open(path)
+}
+
+// DropCache does nothing on this platform, subsequent reads of f may be
+// served from the page cache.
+func DropCache(f *os.File) error {
+	return nil
+}
@@ -28,6 +28,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -47,120 +48,262 @@ type SymlinkBehaviour int
 type QuotaMode int
 type FileExaminer func(parent string, info fs.FileInfo) error
 
+// Options and shared state of a walk. A Context must be set up before the
+// walk starts, it is then safe for concurrent use by multiple walkers.
 type Context struct {
-	SymlinkMode    SymlinkBehaviour
-	DupeDetector   data.DupeDetector
-	DetectorHash   hash.Hash
-	quotaMode      QuotaMode
-	quota          int64 //Quota left in bytes
-	symlinkCounter int
+	SymlinkMode  SymlinkBehaviour
+	Ignore       func(path string) bool //Files are skipped if Ignore is set and returns true for them
+	Filter       *Filter                //Selects files and prunes directories if set
+	DupeDetector *data.DupeDetector
+	DetectorHash func() hash.Hash //Creates the hash for DupeDetector, one is created per examined file
+	Walkers      int              //Number of directories read concurrently, values below 2 walk sequentially
+	Ordered      bool             //Examine files in the order of a sequential walk if Walkers is greater than 1
+	Sorted       bool             //Examine the entries of each directory sorted by name
+	SortDir      string           //Directory for temporary files of sorted walks, the system default if empty
+	quotaMode    QuotaMode
+	quota        atomic.Int64 //Quota left in bytes
 }
 
 func (r *Context) SetQuota(mode QuotaMode, quota int64) {
 	r.quotaMode = mode
-	r.quota = quota
+	r.quota.Store(quota)
 }
 
+// Deducts size from the quota. Returns false and leaves the quota untouched
+// if size exceeds the quota left.
+func (r *Context) reserveQuota(size int64) bool {
+	for {
+		left := r.quota.Load()
+		if size > left {
+			return false
+		}
+		if r.quota.CompareAndSwap(left, left-size) {
+			return true
+		}
+	}
+}
+
+// Maximum number of nested directory symlinks followed by WalkDir.
+const maxSymlinkDepth = 40
+
+// Walks the directory tree at path and calls fileEx for every file. If
+// opts.Walkers is greater than 1, sibling directories are read concurrently
+// and fileEx must be safe for concurrent use unless opts.Ordered is set.
 func WalkDir(path string, opts *Context, fileEx FileExaminer) error {
-	//Stat directory
-	info, err := os.Lstat(path)
+	if opts.Walkers > 1 {
+		return walkParallel(path, opts, fileEx)
+	}
+	return walkDir(path, opts, fileEx, 0, nil)
+}
+
+// Walks the directory at path. symlinkDepth is the number of directory
+// symlinks followed to reach path, ignores are the ignore rules of its parent.
+func walkDir(path string, opts *Context, fileEx FileExaminer, symlinkDepth int, ignores *ignoreList) error {
+	l := listDir(path, opts)
+	ok, symlinkDepth, err := evalListing(path, l, opts, symlinkDepth)
+	if !ok {
+		return err
+	}
+	if ignores, err = opts.loadIgnores(path, ignores); err != nil {
+		l.close()
+		return err
+	}
+	//Loop directory entries
+	err = l.forEachBatch(func(dirEnts []fs.DirEntry) error {
+		for _, dirEnt := range dirEnts {
+			if isDirEntry(dirEnt) {
+				//Recurse into subdirectory
+				subdir := filepath.Join(path, dirEnt.Name())
+				if opts.prunes(subdir, ignores) {
+					continue
+				}
+				if err := walkDir(subdir, opts, fileEx, symlinkDepth, ignores); err != nil {
+					return err
+				}
+			} else if err := examineEntry(path, dirEnt, opts, fileEx, ignores); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, fs.SkipDir) {
+		slog.Debug("walkDir: File executor returned fs.SkipDir, skipping rest of directory", "path", path)
+		return nil
+	}
+	return err
+}
+
+// Directory opened for walking. A dirListing only holds the outcome of the
+// I/O, errors are evaluated by evalListing.
+type dirListing struct {
+	info     fs.FileInfo
+	statErr  error
+	openErr  error
+	reader   dirReader     //nil if the directory was not opened
+	batch    []fs.DirEntry //First batch of entries
+	batchErr error
+}
+
+// Opens the directory at path and reads its first batch of entries.
+// Directory symlinks are only opened if they are to follow.
+func listDir(path string, opts *Context) *dirListing {
+	l := new(dirListing)
+	l.info, l.statErr = os.Lstat(path)
+	if l.statErr != nil {
+		return l
+	}
+	if l.info.Mode()&fs.ModeSymlink != 0 && opts.SymlinkMode != SymlinksRejectNone {
+		return l
+	}
+	f, err := os.Open(path)
 	if err != nil {
-		return softerrors.Consume(err)
+		l.openErr = err
+		return l
+	}
+	if opts.Sorted {
+		//Assigning a failed reader would store a typed nil in l.reader
+		reader, err := newSortedReader(path, f, opts.SortDir)
+		if err != nil {
+			l.openErr = err
+			return l
+		}
+		l.reader = reader
+	} else {
+		l.reader = &streamReader{f: f}
+	}
+	l.batch, l.batchErr = l.reader.next()
+	return l
+}
+
+// Calls fn for every batch of entries until fn returns an error. Errors
+// reading the directory are soft errors that end the directory. The
+// directory is closed afterwards.
+func (r *dirListing) forEachBatch(fn func(dirEnts []fs.DirEntry) error) error {
+	defer r.close()
+	dirEnts, readErr := r.batch, r.batchErr
+	r.batch = nil
+	for {
+		if err := fn(dirEnts); err != nil {
+			return err
+		}
+		if readErr == io.EOF {
+			return nil
+		} else if readErr != nil {
+			return softerrors.Consume(readErr)
+		}
+		dirEnts, readErr = r.reader.next()
+	}
+}
+
+func (r *dirListing) close() {
+	if r.reader == nil {
+		return
+	}
+	if err := r.reader.close(); err != nil {
+		slog.Error(err.Error())
+	}
+	r.reader = nil
+}
+
+// Evaluates the listing l of the directory at path. Returns true and the
+// symlink depth of the directory's subdirectories if its entries are to be
+// walked, l is closed otherwise.
+func evalListing(path string, l *dirListing, opts *Context, symlinkDepth int) (bool, int, error) {
+	if l.statErr != nil {
+		return false, symlinkDepth, softerrors.Consume(l.statErr)
 	}
+	info := l.info
 	//Check if path is a directory
 	if !(info.IsDir() || info.Mode()&(fs.ModeDir|fs.ModeSymlink) != 0) {
-		return fmt.Errorf("Not a directory: %s", path)
+		l.close()
+		return false, symlinkDepth, fmt.Errorf("Not a directory: %s", path)
 	}
 	//Evaluate Symlink
 	if info.Mode()&fs.ModeSymlink != 0 {
 		//Check if symlinks are to follow
 		if opts.SymlinkMode != SymlinksRejectNone {
 			slog.Info("Skipping directory symlink", "path", path)
-			return nil
+			return false, symlinkDepth, nil
 		}
 		//Symlink counter
-		if opts.symlinkCounter >= 40 {
-			return errors.New("Symlink limit reached")
-		}
-		opts.symlinkCounter++
-		defer func() {
-			opts.symlinkCounter--
-			slog.Log(context.Background(), logging.LevelTrace, "Count symlink depth", "depth", opts.symlinkCounter)
-		}()
-		slog.Log(context.Background(), logging.LevelTrace, "Count symlink depth", "depth", opts.symlinkCounter)
-	}
-	//Read directory entries
-	dirEnts, errs := readDirEnts(path)
-	if len(errs) > 0 {
-		for i, err := range errs {
-			if len(errs)-i > 1 {
-				slog.Error(err.Error())
-				continue
-			}
-			if softerrors.Consume(err) != nil {
-				return err
-			}
+		if symlinkDepth >= maxSymlinkDepth {
+			l.close()
+			return false, symlinkDepth, errors.New("Symlink limit reached")
 		}
+		symlinkDepth++
+		slog.Log(context.Background(), logging.LevelTrace, "Count symlink depth", "depth", symlinkDepth)
 	}
-	//Loop directory entries
-	for _, dirEnt := range dirEnts {
-		if dirEnt.IsDir() || dirEnt.Type()&(fs.ModeDir|fs.ModeSymlink) != 0 {
-			//Recurse into subdirectory
-			if err := WalkDir(filepath.Join(path, dirEnt.Name()), opts, fileEx); err != nil {
-				return err
-			}
-		} else {
-			//examine file
-			info, err := dirEnt.Info()
-			if err != nil {
-				if err := softerrors.Errorf("Could not read FileInfo: %s", err); err != nil {
-					return err
-				}
-				continue
-			}
-			if err := examineFile(path, info, opts, fileEx); err != nil {
-				if errors.Is(err, fs.SkipDir) {
-					slog.Debug("walkDir: File executor returned fs.SkipDir, skipping rest of directory", "path", path)
-					return nil
-				}
-				return err
-			}
-		}
+	if l.openErr != nil {
+		return false, symlinkDepth, softerrors.Consume(l.openErr)
 	}
-	return nil
+	return true, symlinkDepth, nil
 }
 
-func ExamineFile(parent string, info fs.FileInfo, opts *Context, fileEx FileExaminer) error {
-	err := examineFile(parent, info, opts, fileEx)
-	if errors.Is(err, fs.SkipDir) {
+// Returns true if dirEnt is a directory or a symlink that may point to one.
+func isDirEntry(dirEnt fs.DirEntry) bool {
+	return dirEnt.IsDir() || dirEnt.Type()&(fs.ModeDir|fs.ModeSymlink) != 0
+}
+
+// Examines the file dirEnt within the directory parent.
+func examineEntry(parent string, dirEnt fs.DirEntry, opts *Context, fileEx FileExaminer, ignores *ignoreList) error {
+	//Skip unselected files before reading their FileInfo, as they may be gone already
+	if !opts.selects(filepath.Join(parent, dirEnt.Name()), ignores) {
 		return nil
 	}
-	return err
+	info, err := dirEnt.Info()
+	if err != nil {
+		return softerrors.Errorf("Could not read FileInfo: %s", err)
+	}
+	return examineFile(parent, info, opts, fileEx)
 }
 
-func readDirEnts(path string) ([]fs.DirEntry, []error) {
-	errs := make([]error, 0)
-	dirEnts := make([]fs.DirEntry, 0)
-	f, err := os.Open(path)
+// Returns true if the file at path is neither ignored nor filtered.
+func (r *Context) selects(path string, ignores *ignoreList) bool {
+	if r.Ignore != nil && r.Ignore(path) {
+		slog.Debug("examineFile: Ignoring file", "path", path)
+		return false
+	}
+	if r.Filter != nil && !r.Filter.selects(path, ignores) {
+		slog.Debug("examineFile: File is filtered", "path", path)
+		return false
+	}
+	return true
+}
+
+// Returns true if the subdirectory at path must not be walked.
+func (r *Context) prunes(path string, ignores *ignoreList) bool {
+	if r.Filter != nil && r.Filter.prunes(path, ignores) {
+		slog.Debug("walkDir: Pruning filtered directory", "path", path)
+		return true
+	}
+	return false
+}
+
+// Returns the ignore rules in effect for the entries of the directory at
+// path, ignores are the rules in effect for path.
+func (r *Context) loadIgnores(path string, ignores *ignoreList) (*ignoreList, error) {
+	if r.Filter == nil {
+		return ignores, nil
+	}
+	ignores, err := r.Filter.loadIgnoreFile(path, ignores)
 	if err != nil {
-		return nil, []error{err}
+		return ignores, softerrors.Consume(err)
 	}
-	defer f.Close()
-	for {
-		entries, err := f.ReadDir(1024)
-		if len(entries) > 0 {
-			dirEnts = append(dirEnts, entries...)
-		}
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			errs = append(errs, err)
-		}
+	return ignores, nil
+}
+
+func ExamineFile(parent string, info fs.FileInfo, opts *Context, fileEx FileExaminer) error {
+	if !opts.selects(filepath.Join(parent, info.Name()), nil) {
+		return nil
 	}
-	return dirEnts, errs
+	err := examineFile(parent, info, opts, fileEx)
+	if errors.Is(err, fs.SkipDir) {
+		return nil
+	}
+	return err
 }
 
+// Examines the selected file info within the directory parent.
 func examineFile(parent string, info fs.FileInfo, opts *Context, fileEx FileExaminer) error {
 	path := filepath.Join(parent, info.Name())
 	//Use DupeDetector for files if available
@@ -169,15 +312,14 @@ func examineFile(parent string, info fs.FileInfo, opts *Context, fileEx FileExam
 		if err != nil {
 			return softerrors.Consume(err)
 		}
-		if err := opts.DupeDetector.Register(strings.NewReader(realPath), opts.DetectorHash); err != nil {
+		if err := opts.DupeDetector.Register(strings.NewReader(realPath), opts.DetectorHash()); err != nil {
 			slog.Debug("examineFile: DupeDetector detected already processed file", "path", path)
 			return nil
 		}
 	}
 	//Check quota on regular files
 	if opts.quotaMode != QuotaDisabled && info.Mode().IsRegular() {
-		opts.quota = opts.quota - info.Size()
-		if opts.quota < 0 {
+		if !opts.reserveQuota(info.Size()) {
 			switch opts.quotaMode {
 			case QuotaCutoff:
 				slog.Debug("examineFile: File exceeds quota in mode QuotaCutoff, aborting...", "path", path)
@@ -0,0 +1,200 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"sync"
+)
+
+// State of a walk that reads up to Context.Walkers directories concurrently.
+type parallelWalk struct {
+	opts    *Context
+	fileEx  FileExaminer
+	sem     chan struct{} //Limits the number of directories read concurrently
+	wg      sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+	errOnce sync.Once
+	err     error
+}
+
+func walkParallel(path string, opts *Context, fileEx FileExaminer) error {
+	w := &parallelWalk{
+		opts:   opts,
+		fileEx: fileEx,
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	defer w.cancel()
+	if opts.Ordered {
+		w.sem = make(chan struct{}, opts.Walkers)
+		w.fail(w.walkOrdered(path, listDir(path, opts), 0, nil))
+	} else {
+		//The calling goroutine is a walker as well
+		w.sem = make(chan struct{}, opts.Walkers-1)
+		w.walkUnordered(path, 0, nil)
+	}
+	w.wg.Wait()
+	return w.err
+}
+
+// Stops the walk and keeps err if it is the first error.
+func (w *parallelWalk) fail(err error) {
+	if err == nil {
+		return
+	}
+	w.errOnce.Do(func() { w.err = err })
+	w.cancel()
+}
+
+// Walks the directory at path, subdirectories are walked on their own
+// goroutine while the limit of concurrent walkers is not reached. The
+// calling goroutine holds no slot, so walking inline never blocks.
+func (w *parallelWalk) walkUnordered(path string, symlinkDepth int, ignores *ignoreList) {
+	if w.ctx.Err() != nil {
+		return
+	}
+	l := listDir(path, w.opts)
+	ok, symlinkDepth, err := evalListing(path, l, w.opts, symlinkDepth)
+	if !ok {
+		w.fail(err)
+		return
+	}
+	if ignores, err = w.opts.loadIgnores(path, ignores); err != nil {
+		l.close()
+		w.fail(err)
+		return
+	}
+	err = l.forEachBatch(func(dirEnts []fs.DirEntry) error {
+		for _, dirEnt := range dirEnts {
+			if err := w.ctx.Err(); err != nil {
+				return err
+			}
+			if isDirEntry(dirEnt) {
+				subdir := filepath.Join(path, dirEnt.Name())
+				if w.opts.prunes(subdir, ignores) {
+					continue
+				}
+				select {
+				case w.sem <- struct{}{}:
+					w.wg.Add(1)
+					go func() {
+						defer w.wg.Done()
+						defer func() { <-w.sem }()
+						w.walkUnordered(subdir, symlinkDepth, ignores)
+					}()
+				default:
+					w.walkUnordered(subdir, symlinkDepth, ignores)
+				}
+			} else if err := examineEntry(path, dirEnt, w.opts, w.fileEx, ignores); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, fs.SkipDir) {
+		slog.Debug("walkDir: File executor returned fs.SkipDir, skipping rest of directory", "path", path)
+	} else if err != nil && !errors.Is(err, context.Canceled) {
+		w.fail(err)
+	}
+}
+
+// Directory listing that is read in the background.
+type pendingListing struct {
+	done    chan struct{}
+	listing *dirListing
+}
+
+// Starts reading the directory at path in the background.
+func (w *parallelWalk) prefetch(path string) *pendingListing {
+	p := &pendingListing{done: make(chan struct{})}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer close(p.done)
+		w.sem <- struct{}{}
+		defer func() { <-w.sem }()
+		p.listing = listDir(path, w.opts)
+	}()
+	return p
+}
+
+// Walks the directory at path in the same order as walkDir, fileEx is only
+// called by the calling goroutine. Up to Walkers subdirectories of every
+// directory on the current path are read ahead of the walk.
+func (w *parallelWalk) walkOrdered(path string, listing *dirListing, symlinkDepth int, ignores *ignoreList) error {
+	ok, symlinkDepth, err := evalListing(path, listing, w.opts, symlinkDepth)
+	if !ok {
+		return err
+	}
+	if ignores, err = w.opts.loadIgnores(path, ignores); err != nil {
+		listing.close()
+		return err
+	}
+	subdirs := make([]string, 0)
+	pending := make([]*pendingListing, 0, w.opts.Walkers)
+	defer func() {
+		//Close directories read ahead of an aborted walk
+		for _, p := range pending {
+			<-p.done
+			p.listing.close()
+		}
+	}()
+	readAhead := func() {
+		for len(subdirs) > 0 && len(pending) < w.opts.Walkers {
+			pending = append(pending, w.prefetch(subdirs[0]))
+			subdirs = subdirs[1:]
+		}
+	}
+	err = listing.forEachBatch(func(dirEnts []fs.DirEntry) error {
+		pruned := make([]bool, len(dirEnts))
+		for i, dirEnt := range dirEnts {
+			if isDirEntry(dirEnt) {
+				subdir := filepath.Join(path, dirEnt.Name())
+				if pruned[i] = w.opts.prunes(subdir, ignores); !pruned[i] {
+					subdirs = append(subdirs, subdir)
+				}
+			}
+		}
+		readAhead()
+		for i, dirEnt := range dirEnts {
+			if pruned[i] {
+				continue
+			}
+			if isDirEntry(dirEnt) {
+				//Subdirectories are read ahead in order, the next one is always first in line
+				p := pending[0]
+				pending = pending[1:]
+				readAhead()
+				<-p.done
+				if err := w.walkOrdered(filepath.Join(path, dirEnt.Name()), p.listing, symlinkDepth, ignores); err != nil {
+					return err
+				}
+			} else if err := examineEntry(path, dirEnt, w.opts, w.fileEx, ignores); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, fs.SkipDir) {
+		slog.Debug("walkDir: File executor returned fs.SkipDir, skipping rest of directory", "path", path)
+		return nil
+	}
+	return err
+}
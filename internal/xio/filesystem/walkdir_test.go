@@ -0,0 +1,288 @@
+//This file is part of xtagger. ©2023 Jörg Walter.
+//This program is free software: you can redistribute it and/or modify
+//it under the terms of the GNU General Public License as published by
+//the Free Software Foundation, either version 3 of the License, or
+//(at your option) any later version.
+//
+//This program is distributed in the hope that it will be useful,
+//but WITHOUT ANY WARRANTY; without even the implied warranty of
+//MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//GNU General Public License for more details.
+//
+//You should have received a copy of the GNU General Public License
+//along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/jwdev42/xtagger/internal/data"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const testWalkers = 16
+
+// Creates dirs directories with files files of size bytes each below root.
+func createTree(t *testing.T, root string, dirs, files, size int) {
+	content := make([]byte, size)
+	for d := range dirs {
+		dir := filepath.Join(root, fmt.Sprintf("dir%d", d))
+		if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		for f := range files {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d", f)), content, 0644); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "sub", fmt.Sprintf("file%d", f)), content, 0644); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+		}
+	}
+}
+
+// Walks root with testWalkers concurrent walkers sharing opts.
+func walkConcurrently(t *testing.T, root string, opts *Context, fileEx FileExaminer) []error {
+	errs := make([]error, testWalkers)
+	var wg sync.WaitGroup
+	for i := range testWalkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = WalkDir(root, opts, fileEx)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestConcurrentDupeDetection(t *testing.T) {
+	root := t.TempDir()
+	createTree(t, root, 10, 20, 0)
+	opts := &Context{
+		DupeDetector: data.NewDupeDetector(),
+		DetectorHash: sha256.New,
+	}
+	var examined sync.Map
+	var total atomic.Int64
+	errs := walkConcurrently(t, root, opts, func(parent string, info fs.FileInfo) error {
+		total.Add(1)
+		if _, loaded := examined.LoadOrStore(filepath.Join(parent, info.Name()), true); loaded {
+			t.Errorf("%s was examined twice", filepath.Join(parent, info.Name()))
+		}
+		return nil
+	})
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+	}
+	if total.Load() != 10*20*2 {
+		t.Errorf("Expected %d examined files, got %d", 10*20*2, total.Load())
+	}
+}
+
+func TestConcurrentQuota(t *testing.T) {
+	const size, fitting = 100, 37
+	root := t.TempDir()
+	createTree(t, root, 4, 10, size)
+	for _, mode := range []QuotaMode{QuotaSkip, QuotaCutoff} {
+		opts := new(Context)
+		opts.SetQuota(mode, size*fitting+size/2)
+		var examined atomic.Int64
+		walkConcurrently(t, root, opts, func(parent string, info fs.FileInfo) error {
+			examined.Add(info.Size())
+			return nil
+		})
+		if examined.Load() != size*fitting {
+			t.Errorf("Mode %d: Expected %d bytes to pass the quota, got %d", mode, size*fitting, examined.Load())
+		}
+		if left := opts.quota.Load(); left != size/2 {
+			t.Errorf("Mode %d: Expected %d bytes of quota left, got %d", mode, size/2, left)
+		}
+	}
+}
+
+func TestConcurrentSymlinkLimit(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Symlink(".", filepath.Join(root, "loop")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "file"), nil, 0644); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	opts := &Context{SymlinkMode: SymlinksRejectNone}
+	var examined atomic.Int64
+	errs := walkConcurrently(t, root, opts, func(parent string, info fs.FileInfo) error {
+		examined.Add(1)
+		return nil
+	})
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("Walker %d: Expected the symlink limit to be reached", i)
+		}
+	}
+	//Every walker examines the file once at each depth up to the limit
+	if expected := int64(testWalkers * (maxSymlinkDepth + 1)); examined.Load() != expected {
+		t.Errorf("Expected %d examined files, got %d", expected, examined.Load())
+	}
+}
+
+// Walks root and returns the paths of all examined files in the order they were examined.
+func walkPaths(t *testing.T, root string, opts *Context) []string {
+	var mu sync.Mutex
+	paths := make([]string, 0)
+	if err := WalkDir(root, opts, func(parent string, info fs.FileInfo) error {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, filepath.Join(parent, info.Name()))
+		return nil
+	}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return paths
+}
+
+func TestParallelWalk(t *testing.T) {
+	root := t.TempDir()
+	createTree(t, root, 20, 10, 0)
+	expected := walkPaths(t, root, new(Context))
+	if len(expected) != 20*10*2 {
+		t.Fatalf("Expected %d examined files, got %d", 20*10*2, len(expected))
+	}
+	for _, walkers := range []int{2, 3, 16} {
+		ordered := walkPaths(t, root, &Context{Walkers: walkers, Ordered: true})
+		if slices.Compare(ordered, expected) != 0 {
+			t.Errorf("Walkers %d: Ordered walk differs from the sequential walk", walkers)
+		}
+		unordered := walkPaths(t, root, &Context{Walkers: walkers})
+		slices.Sort(unordered)
+		sorted := slices.Clone(expected)
+		slices.Sort(sorted)
+		if slices.Compare(unordered, sorted) != 0 {
+			t.Errorf("Walkers %d: Unordered walk did not examine the same files as the sequential walk", walkers)
+		}
+	}
+}
+
+func TestParallelWalkError(t *testing.T) {
+	root := t.TempDir()
+	createTree(t, root, 20, 10, 0)
+	errTest := errors.New("test error")
+	for _, ordered := range []bool{false, true} {
+		var examined atomic.Int64
+		err := WalkDir(root, &Context{Walkers: 4, Ordered: ordered}, func(parent string, info fs.FileInfo) error {
+			if examined.Add(1) == 50 {
+				return errTest
+			}
+			return nil
+		})
+		if !errors.Is(err, errTest) {
+			t.Errorf("Ordered %t: Expected the examiner's error, got %v", ordered, err)
+		}
+		if ordered && examined.Load() != 50 {
+			t.Errorf("Ordered %t: Walk continued after an error, examined %d files", ordered, examined.Load())
+		}
+	}
+}
+
+func TestSortedWalk(t *testing.T) {
+	defer func(size int) { sortRunSize = size }(sortRunSize)
+	root := t.TempDir()
+	//More entries than a batch, so runs are merged over several batches
+	const files = dirBatchSize*2 + 500
+	for i := range files {
+		if err := os.WriteFile(filepath.Join(root, fmt.Sprintf("%x", i*7919%files)), nil, 0644); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	createTree(t, root, 5, 10, 0)
+	expected := walkPaths(t, root, new(Context))
+	slices.Sort(expected)
+	for _, runSize := range []int{1 << 16, 1000, 7} {
+		sortRunSize = runSize
+		sortDir := t.TempDir()
+		for _, walkers := range []int{0, 4} {
+			paths := walkPaths(t, root, &Context{Sorted: true, SortDir: sortDir, Walkers: walkers, Ordered: true})
+			if slices.Compare(paths, expected) != 0 {
+				t.Errorf("Run size %d, walkers %d: Sorted walk did not examine all files in sorted order", runSize, walkers)
+			}
+		}
+		if ents, err := os.ReadDir(sortDir); err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		} else if len(ents) > 0 {
+			t.Errorf("Run size %d: %d temporary files were left behind", runSize, len(ents))
+		}
+	}
+}
+
+func TestSortedWalkSortError(t *testing.T) {
+	defer func(size int) { sortRunSize = size }(sortRunSize)
+	sortRunSize = 7
+	root := t.TempDir()
+	for _, dir := range []string{"d1", "d2", "d3", "d4"} {
+		for i := range 10 {
+			if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if err := os.WriteFile(filepath.Join(root, dir, fmt.Sprintf("file%d", i)), nil, 0644); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "a"), 0755); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "file"), nil, 0644); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	//Runs can't be written to a missing directory, so directories with more entries than a run fail
+	sortDir := filepath.Join(root, "missing")
+	for _, walkers := range []int{0, 4} {
+		opts := &Context{Sorted: true, SortDir: sortDir, Walkers: walkers, Ordered: true}
+		if paths := walkPaths(t, root, opts); len(paths) != 1 {
+			t.Errorf("Walkers %d: Expected only the file in a to be examined, got %v", walkers, paths)
+		}
+		//Aborting the walk closes the failed listings that were read ahead
+		if err := WalkDir(root, opts, func(parent string, info fs.FileInfo) error {
+			return fs.SkipAll
+		}); err != nil && !errors.Is(err, fs.SkipAll) {
+			t.Errorf("Walkers %d: Unexpected error: %s", walkers, err)
+		}
+	}
+}
+
+func TestStreamedWalk(t *testing.T) {
+	root := t.TempDir()
+	const files = dirBatchSize*3 + 1
+	for i := range files {
+		if err := os.WriteFile(filepath.Join(root, fmt.Sprintf("file%d", i)), nil, 0644); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	if paths := walkPaths(t, root, new(Context)); len(paths) != files {
+		t.Errorf("Expected %d examined files, got %d", files, len(paths))
+	}
+	//SkipDir ends the directory after the current file
+	var examined int
+	if err := WalkDir(root, new(Context), func(parent string, info fs.FileInfo) error {
+		examined++
+		if examined == dirBatchSize+1 {
+			return fs.SkipDir
+		}
+		return nil
+	}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if examined != dirBatchSize+1 {
+		t.Errorf("Expected %d examined files, got %d", dirBatchSize+1, examined)
+	}
+}
@@ -37,3 +37,16 @@ func (r *Printer) Print0(message string) (n int, err error) {
 	r.mu.Lock()
 	return fmt.Fprintf(r.wr, "%s%c", message, 0)
 }
+
+func (r *Printer) Printf(format string, a ...any) (n int, err error) {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	return fmt.Fprintf(r.wr, format, a...)
+}
+
+// Writes p in a single call to the underlying writer.
+func (r *Printer) Write(p []byte) (n int, err error) {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	return r.wr.Write(p)
+}